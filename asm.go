@@ -0,0 +1,306 @@
+package g9cc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 出力するアセンブリを文字列ではなく命令の列として持つ。
+// コード生成はこの列に命令を積み、peephole.go がそれを書き換えてから文字列にする。
+
+type operandKind int
+
+const (
+	opdReg operandKind = iota // レジスタ
+	opdImm                    // 即値
+	opdMem                    // メモリ [base + index*scale + disp] または sym[rip]
+	opdSym                    // ジャンプ先・呼び出し先のシンボル
+)
+
+type asmOperand struct {
+	kind  operandKind
+	reg   string // opdReg
+	imm   int    // opdImm
+	base  string // opdMem
+	index string // opdMem（なければ ""）
+	scale int    // opdMem
+	disp  int    // opdMem
+	sym   string // opdSym、opdMem の rip 相対アドレス
+	size  int    // opdMem のアクセス幅（0 なら PTR を書かない）
+}
+
+func regOp(name string) asmOperand {
+	return asmOperand{kind: opdReg, reg: name}
+}
+
+func immOp(v int) asmOperand {
+	return asmOperand{kind: opdImm, imm: v}
+}
+
+// memOp は [base + disp] を表す
+func memOp(base string, disp int) asmOperand {
+	return asmOperand{kind: opdMem, base: base, disp: disp}
+}
+
+// ripOp は sym[rip] を表す
+func ripOp(sym string) asmOperand {
+	return asmOperand{kind: opdMem, sym: sym}
+}
+
+func symOp(name string) asmOperand {
+	return asmOperand{kind: opdSym, sym: name}
+}
+
+// ptr はメモリオペランドにアクセス幅を付ける（BYTE/DWORD/QWORD PTR）
+func (o asmOperand) ptr(size int) asmOperand {
+	o.size = size
+	return o
+}
+
+// regs はオペランドが参照するレジスタ（64 ビットの名前）を返す
+func (o asmOperand) regs() []string {
+	switch o.kind {
+	case opdReg:
+		return []string{reg64(o.reg)}
+	case opdMem:
+		var rs []string
+		if o.base != "" {
+			rs = append(rs, reg64(o.base))
+		}
+		if o.index != "" {
+			rs = append(rs, reg64(o.index))
+		}
+		return rs
+	}
+	return nil
+}
+
+// asmSyntax は出力するアセンブリの記法（-masm=intel|att）
+type asmSyntax int
+
+const (
+	syntaxIntel asmSyntax = iota // Intel 記法（既定）
+	syntaxATT                    // AT&T 記法
+)
+
+var ptrNames = map[int]string{1: "BYTE PTR ", 2: "WORD PTR ", 4: "DWORD PTR ", 8: "QWORD PTR "}
+
+// attSuffixes はアクセス幅ごとの AT&T 記法の命令の接尾辞
+var attSuffixes = map[int]string{1: "b", 2: "w", 4: "l", 8: "q"}
+
+func (o asmOperand) String() string {
+	return o.format(syntaxIntel)
+}
+
+// format はオペランドを記法 s で書く
+func (o asmOperand) format(s asmSyntax) string {
+	if s == syntaxATT {
+		return o.att()
+	}
+	switch o.kind {
+	case opdReg:
+		return o.reg
+	case opdImm:
+		return fmt.Sprint(o.imm)
+	case opdSym:
+		return o.sym
+	}
+
+	if o.sym != "" {
+		return fmt.Sprintf("%s%s[rip]", ptrNames[o.size], o.sym)
+	}
+	var sb strings.Builder
+	sb.WriteString(ptrNames[o.size])
+	sb.WriteString("[" + o.base)
+	if o.index != "" {
+		fmt.Fprintf(&sb, " + %s*%d", o.index, o.scale)
+	}
+	switch {
+	case o.disp > 0:
+		fmt.Fprintf(&sb, " + %d", o.disp)
+	case o.disp < 0:
+		fmt.Fprintf(&sb, " - %d", -o.disp)
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// att はオペランドを AT&T 記法（%rax, $1, -8(%rbp), sym(%rip)）で書く
+func (o asmOperand) att() string {
+	switch o.kind {
+	case opdReg:
+		return "%" + o.reg
+	case opdImm:
+		return fmt.Sprintf("$%d", o.imm)
+	case opdSym:
+		return o.sym
+	}
+
+	if o.sym != "" {
+		return o.sym + "(%rip)"
+	}
+	disp := ""
+	if o.disp != 0 {
+		disp = fmt.Sprint(o.disp)
+	}
+	if o.index != "" {
+		return fmt.Sprintf("%s(%%%s,%%%s,%d)", disp, o.base, o.index, o.scale)
+	}
+	return fmt.Sprintf("%s(%%%s)", disp, o.base)
+}
+
+type asmKind int
+
+const (
+	asmInsn      asmKind = iota // 命令
+	asmLabel                    // ラベル
+	asmDirective                // ディレクティブなど、そのまま出力する行
+)
+
+type asmInst struct {
+	kind asmKind
+	op   string
+	args []asmOperand
+	text string // asmLabel のラベル名、asmDirective の行
+	// meta は命令の意味に関わらない行（-g の .loc・.cfi_*、-fverbose-asm のコメント）。
+	// peephole 最適化はこれを読み飛ばす。
+	meta    bool
+	comment string // 行末に付けるコメント（-fverbose-asm）
+}
+
+func (in *asmInst) String() string {
+	return in.format(syntaxIntel)
+}
+
+// format は 1 行を記法 s で書く
+func (in *asmInst) format(s asmSyntax) string {
+	switch in.kind {
+	case asmLabel:
+		if in.comment != "" {
+			return in.text + ":\t# " + in.comment
+		}
+		return in.text + ":"
+	case asmDirective:
+		return in.text
+	}
+	op, args := in.op, in.args
+	if s == syntaxATT {
+		op = in.attOp()
+		args = slices.Clone(args)
+		slices.Reverse(args) // AT&T 記法は読む側が先、書く側が後
+	}
+	if len(args) == 0 {
+		return "\t" + op
+	}
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = a.format(s)
+	}
+	return "\t" + op + " " + strings.Join(strs, ", ")
+}
+
+// attOp は AT&T 記法の命令名を返す。
+// 符号・ゼロ拡張は幅を名前に含め（movsx rax, BYTE PTR [rax] -> movsbq）、
+// レジスタのオペランドがなく幅が決まらない命令には接尾辞を付ける（mov DWORD PTR [rax], 1 -> movl）。
+func (in *asmInst) attOp() string {
+	switch in.op {
+	case "cqo":
+		return "cqto"
+	case "movsx", "movsxd", "movzx":
+		return in.op[:4] + attSuffixes[in.args[1].width()] + attSuffixes[in.args[0].width()]
+	}
+	size := 0
+	for _, a := range in.args {
+		switch a.kind {
+		case opdReg:
+			return in.op
+		case opdMem:
+			size = a.size
+		case opdImm:
+			if size == 0 {
+				size = 8 // push 1 は 8 バイトを積む
+			}
+		}
+	}
+	return in.op + attSuffixes[size]
+}
+
+// width はレジスタかメモリのオペランドの幅を返す
+func (o asmOperand) width() int {
+	if o.kind == opdReg {
+		return regSizes[o.reg]
+	}
+	return o.size
+}
+
+// emit は命令を 1 つ積む
+func (g *generator) emit(op string, args ...asmOperand) {
+	g.insts = append(g.insts, &asmInst{kind: asmInsn, op: op, args: args})
+}
+
+func (g *generator) label(format string, args ...any) {
+	g.insts = append(g.insts, &asmInst{kind: asmLabel, text: fmt.Sprintf(format, args...)})
+}
+
+func (g *generator) directive(format string, args ...any) {
+	g.insts = append(g.insts, &asmInst{kind: asmDirective, text: fmt.Sprintf(format, args...)})
+}
+
+// debugDirective はデバッグ情報のディレクティブを積む（-g のときだけ）
+func (g *generator) debugDirective(format string, args ...any) {
+	if g.dbg == nil {
+		return
+	}
+	g.insts = append(g.insts, &asmInst{kind: asmDirective, text: fmt.Sprintf(format, args...), meta: true})
+}
+
+// flush は積んだ命令を（有効なら peephole 最適化してから）書き出す
+func (g *generator) flush() error {
+	if g.peephole {
+		g.insts = peephole(g.insts, g.stats)
+		if g.statsOut != nil {
+			writePeepholeStats(g.statsOut, g.stats)
+		}
+	}
+	for _, in := range g.insts {
+		fmt.Fprintln(g.w, in.format(g.syntax))
+	}
+	g.insts = nil
+	return g.w.Flush()
+}
+
+var (
+	subregs32 = map[string]string{
+		"rax": "eax", "rbx": "ebx", "rcx": "ecx", "rdx": "edx", "rsi": "esi", "rdi": "edi",
+		"r8": "r8d", "r9": "r9d", "r10": "r10d", "r11": "r11d",
+		"r12": "r12d", "r13": "r13d", "r14": "r14d", "r15": "r15d",
+	}
+	subregs8 = map[string]string{
+		"rax": "al", "rbx": "bl", "rcx": "cl", "rdx": "dl", "rsi": "sil", "rdi": "dil",
+		"r8": "r8b", "r9": "r9b", "r10": "r10b", "r11": "r11b",
+		"r12": "r12b", "r13": "r13b", "r14": "r14b", "r15": "r15b",
+	}
+	// regNames64 はサブレジスタの名前から 64 ビットの名前を引く
+	regNames64 = map[string]string{}
+	// regSizes はレジスタの名前からバイト数を引く
+	regSizes = map[string]int{}
+)
+
+func init() {
+	for r64, r32 := range subregs32 {
+		regNames64[r64], regNames64[r32], regNames64[subregs8[r64]] = r64, r64, r64
+		regSizes[r64], regSizes[r32], regSizes[subregs8[r64]] = 8, 4, 1
+	}
+	for _, r := range []string{"rbp", "rsp"} {
+		regNames64[r] = r
+		regSizes[r] = 8
+	}
+}
+
+func reg64(name string) string {
+	if r, ok := regNames64[name]; ok {
+		return r
+	}
+	return name
+}
@@ -0,0 +1,144 @@
+package g9cc
+
+// ここでは内部の token/node/obj/ty を外部公開用の型に写す。
+// 内部表現を変えても公開 API が変わらないよう、公開型は値のコピーで持つ。
+
+// Token は字句解析の結果の1トークン
+type Token struct {
+	Kind string   `json:"kind"`
+	Text string   `json:"text"`
+	Val  int      `json:"val,omitempty"` // Kind が "num" のときの値
+	Pos  Position `json:"pos"`
+}
+
+// Program は型付き AST 全体
+type Program struct {
+	Funcs   []*Func `json:"funcs"`
+	Globals []*Var  `json:"globals"`
+}
+
+// Func は関数定義
+type Func struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Pos    Position `json:"pos"`
+	Params []*Var   `json:"params"`
+	Locals []*Var   `json:"locals"` // 引数を含む
+	Body   *Node    `json:"body"`
+	Inline bool     `json:"inline,omitempty"` // inline 指定
+}
+
+// Var は変数（ローカル・グローバル・文字列リテラル）
+type Var struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Pos    Position `json:"pos"` // 宣言された位置（文字列リテラルには位置がない）
+	Local  bool     `json:"local"`
+	Offset int      `json:"offset,omitempty"` // ローカル変数の rbp からのオフセット
+	Init   []byte   `json:"init,omitempty"`   // 初期化データ
+}
+
+// Node は AST のノード。Kind に応じて使うフィールドが決まる。
+type Node struct {
+	Kind  string   `json:"kind"`
+	Type  string   `json:"type,omitempty"`
+	Pos   Position `json:"pos"`
+	Val   int      `json:"val,omitempty"`   // num
+	Var   string   `json:"var,omitempty"`   // var
+	Func  string   `json:"func,omitempty"`  // funcall
+	Lhs   *Node    `json:"lhs,omitempty"`   // 単項・二項演算, expr-stmt, return, while の条件
+	Rhs   *Node    `json:"rhs,omitempty"`   // 二項演算, while の本体
+	Cond  *Node    `json:"cond,omitempty"`  // if, for
+	Then  *Node    `json:"then,omitempty"`  // if, for
+	Els   *Node    `json:"els,omitempty"`   // if
+	Init  *Node    `json:"init,omitempty"`  // for
+	Inc   *Node    `json:"inc,omitempty"`   // for
+	Args  []*Node  `json:"args,omitempty"`  // funcall
+	Stmts []*Node  `json:"stmts,omitempty"` // block
+}
+
+func (c *compilation) pos(tok *token) Position {
+	if tok == nil {
+		return Position{Filename: c.filename}
+	}
+	p, _ := position(c.input, tok.pos)
+	p.Filename = c.filename
+	return p
+}
+
+func (c *compilation) exportTokens(tok *token) []Token {
+	var toks []Token
+	for ; tok != nil; tok = tok.next {
+		toks = append(toks, Token{Kind: tok.kind.String(), Text: tok.str, Val: tok.val, Pos: c.pos(tok)})
+	}
+	return toks
+}
+
+func (c *compilation) exportProgram(prog *obj) *Program {
+	out := &Program{}
+	for v := prog; v != nil; v = v.next {
+		if v.isFunction {
+			out.Funcs = append(out.Funcs, c.exportFunc(v))
+		} else {
+			out.Globals = append(out.Globals, c.exportVar(v))
+		}
+	}
+	return out
+}
+
+func (c *compilation) exportFunc(fn *obj) *Func {
+	f := &Func{Name: *fn.name, Type: fn.ty.String(), Pos: c.pos(fn.tok), Body: c.exportNode(fn.body), Inline: fn.isInline}
+	for v := fn.params; v != nil; v = v.next {
+		f.Params = append(f.Params, c.exportVar(v))
+	}
+	for v := fn.locals; v != nil; v = v.next {
+		f.Locals = append(f.Locals, c.exportVar(v))
+	}
+	return f
+}
+
+func (c *compilation) exportVar(v *obj) *Var {
+	out := &Var{Name: *v.name, Type: v.ty.String(), Pos: c.pos(v.tok), Local: v.isLocal, Offset: v.offset}
+	if v.initData != nil {
+		out.Init = []byte(*v.initData)
+	}
+	return out
+}
+
+func (c *compilation) exportNode(n *node) *Node {
+	if n == nil {
+		return nil
+	}
+	out := &Node{
+		Kind: n.kind.String(),
+		Pos:  c.pos(n.tok),
+		Cond: c.exportNode(n.cond),
+		Then: c.exportNode(n.then),
+		Els:  c.exportNode(n.els),
+		Init: c.exportNode(n.init),
+		Inc:  c.exportNode(n.inc),
+		Rhs:  c.exportNode(n.rhs),
+	}
+	if n.ty != nil {
+		out.Type = n.ty.String()
+	}
+	switch n.kind {
+	case ndNum:
+		out.Val = n.val
+	case ndVar:
+		out.Var = *n.lvar.name
+	case ndFuncall:
+		out.Func = n.funcname
+	}
+	if n.kind == ndBlock {
+		for s := n.lhs; s != nil; s = s.next {
+			out.Stmts = append(out.Stmts, c.exportNode(s))
+		}
+	} else {
+		out.Lhs = c.exportNode(n.lhs)
+	}
+	for _, arg := range n.args {
+		out.Args = append(out.Args, c.exportNode(arg))
+	}
+	return out
+}
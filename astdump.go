@@ -0,0 +1,207 @@
+package g9cc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// -ast-dump: 公開用の AST（Program）を字下げした木、JSON、Graphviz の dot で書く。
+// 既定では sema の後、定数を畳み込む前の AST なので、定数の添字も i*size の乗算のまま見える。
+// -ast-dump-before-sema なら sema の前の AST なので、式には型がなく、
+// sizeof やポインタ演算の書き換え（要素の大きさの乗算、ポインタの差の除算）もまだない。
+
+func dumpAST(prog *Program, format string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(prog, "", "  ")
+		if err != nil {
+			return err
+		}
+		bw.Write(data)
+		bw.WriteByte('\n')
+	case "dot":
+		dumpDot(prog, bw)
+	default:
+		dumpText(prog, bw)
+	}
+	return bw.Flush()
+}
+
+// astChild は子ノードと、親から見た役割（lhs, cond など）
+type astChild struct {
+	role string
+	node *Node
+}
+
+// children は n の子ノードを順に返す。while の条件と本体は lhs と rhs に入っている。
+func (n *Node) children() []astChild {
+	var out []astChild
+	add := func(role string, c *Node) {
+		if c != nil {
+			out = append(out, astChild{role, c})
+		}
+	}
+	if n.Kind == "while" {
+		add("cond", n.Lhs)
+		add("body", n.Rhs)
+		return out
+	}
+	add("init", n.Init)
+	add("cond", n.Cond)
+	add("inc", n.Inc)
+	add("then", n.Then)
+	add("else", n.Els)
+	add("lhs", n.Lhs)
+	add("rhs", n.Rhs)
+	for i, arg := range n.Args {
+		add(fmt.Sprintf("arg%d", i), arg)
+	}
+	for _, stmt := range n.Stmts {
+		add("", stmt)
+	}
+	return out
+}
+
+// summary はノードの種類と値・名前・型を 1 行にする
+func (n *Node) summary() string {
+	s := n.Kind
+	switch n.Kind {
+	case "num":
+		s += fmt.Sprintf(" %d", n.Val)
+	case "var":
+		s += " " + n.Var
+	case "funcall":
+		s += " " + n.Func
+	}
+	if n.Type != "" {
+		s += " '" + n.Type + "'"
+	}
+	return s
+}
+
+// shortPos は位置を line:col にする。位置がなければ空文字列。
+func shortPos(p Position) string {
+	if p.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// sortedLocals は引数以外のローカル変数を宣言した順（オフセットの順）に返す
+func (f *Func) sortedLocals() []*Var {
+	params := map[string]bool{}
+	for _, v := range f.Params {
+		params[v.Name] = true
+	}
+	var locals []*Var
+	for _, v := range f.Locals {
+		if !params[v.Name] {
+			locals = append(locals, v)
+		}
+	}
+	sort.SliceStable(locals, func(i, j int) bool { return locals[i].Offset < locals[j].Offset })
+	return locals
+}
+
+func dumpText(prog *Program, w *bufio.Writer) {
+	line := func(depth int, s string, pos Position) {
+		w.WriteString(strings.Repeat("  ", depth) + s)
+		if p := shortPos(pos); p != "" {
+			w.WriteString(" <" + p + ">")
+		}
+		w.WriteByte('\n')
+	}
+	var node func(depth int, role string, n *Node)
+	node = func(depth int, role string, n *Node) {
+		s := n.summary()
+		switch role {
+		case "", "lhs", "rhs":
+		default:
+			if !strings.HasPrefix(role, "arg") {
+				s = role + ": " + s
+			}
+		}
+		line(depth, s, n.Pos)
+		for _, c := range n.children() {
+			node(depth+1, c.role, c.node)
+		}
+	}
+
+	for _, v := range prog.Globals {
+		s := fmt.Sprintf("global %s '%s'", v.Name, v.Type)
+		if v.Init != nil {
+			s += fmt.Sprintf(" init %q", v.Init)
+		}
+		line(0, s, v.Pos)
+	}
+	for _, f := range prog.Funcs {
+		s := fmt.Sprintf("func %s '%s'", f.Name, f.Type)
+		if f.Inline {
+			s += " inline"
+		}
+		line(0, s, f.Pos)
+		for _, v := range f.Params {
+			line(1, fmt.Sprintf("param %s '%s' offset %d", v.Name, v.Type, v.Offset), v.Pos)
+		}
+		for _, v := range f.sortedLocals() {
+			line(1, fmt.Sprintf("local %s '%s' offset %d", v.Name, v.Type, v.Offset), v.Pos)
+		}
+		node(1, "", f.Body)
+	}
+}
+
+// dotLabel は dot のラベルにする。行は \n で区切る。
+func dotLabel(lines ...string) string {
+	var out []string
+	for _, l := range lines {
+		if l != "" {
+			out = append(out, strings.ReplaceAll(l, `"`, `\"`))
+		}
+	}
+	return `"` + strings.Join(out, `\n`) + `"`
+}
+
+func dumpDot(prog *Program, w *bufio.Writer) {
+	fmt.Fprintln(w, "digraph ast {")
+	fmt.Fprintln(w, "  graph [ordering=out];")
+	fmt.Fprintln(w, `  node [shape=box, fontname="monospace"];`)
+
+	id := 0
+	var node func(n *Node) string
+	node = func(n *Node) string {
+		name := fmt.Sprintf("n%d", id)
+		id++
+		fmt.Fprintf(w, "  %s [label=%s];\n", name, dotLabel(n.summary(), shortPos(n.Pos)))
+		for _, c := range n.children() {
+			child := node(c.node)
+			if c.role == "" {
+				fmt.Fprintf(w, "  %s -> %s;\n", name, child)
+			} else {
+				fmt.Fprintf(w, "  %s -> %s [label=%s];\n", name, child, dotLabel(c.role))
+			}
+		}
+		return name
+	}
+
+	for i, v := range prog.Globals {
+		fmt.Fprintf(w, "  g%d [label=%s, shape=ellipse];\n", i, dotLabel("global "+v.Name, "'"+v.Type+"'", shortPos(v.Pos)))
+	}
+	for i, f := range prog.Funcs {
+		name := fmt.Sprintf("f%d", i)
+		var vars []string
+		for _, v := range f.Params {
+			vars = append(vars, fmt.Sprintf("param %s '%s'", v.Name, v.Type))
+		}
+		for _, v := range f.sortedLocals() {
+			vars = append(vars, fmt.Sprintf("local %s '%s'", v.Name, v.Type))
+		}
+		fmt.Fprintf(w, "  %s [label=%s, shape=ellipse];\n", name, dotLabel(append([]string{"func " + f.Name + " '" + f.Type + "'", shortPos(f.Pos)}, vars...)...))
+		fmt.Fprintf(w, "  %s -> %s [label=body];\n", name, node(f.Body))
+	}
+	fmt.Fprintln(w, "}")
+}
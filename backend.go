@@ -0,0 +1,92 @@
+package g9cc
+
+import "io"
+
+// backend はターゲットごとのコード生成。フロントエンド（tokenize -> parse -> sema）が作った
+// 型付き AST を受け取り、アセンブリを書く。
+type backend interface {
+	generate(prog *obj, w io.Writer, c *compilation) error
+	maxArgs() int // レジスタで渡せる引数の数（関数の引数の上限）
+}
+
+// x86Backend は x86-64 のコード生成。-O0 では AST から直接、
+// それ以外（-fir-codegen や -O1 以上）では IR を経由して生成する。
+type x86Backend struct{}
+
+func (x86Backend) generate(prog *obj, w io.Writer, c *compilation) error {
+	if !c.opts.IRCodegen && c.opts.OptLevel == 0 && c.opts.DumpPasses == nil {
+		return codegen(prog, w, c)
+	}
+	ir, err := lowerAndOptimize(prog, c)
+	if err != nil {
+		return err
+	}
+	return codegenIR(ir, w, c)
+}
+
+func (x86Backend) maxArgs() int { return len(argregs64) }
+
+type aarch64Backend struct{}
+
+func (aarch64Backend) generate(prog *obj, w io.Writer, c *compilation) error {
+	return codegenAArch64(prog, w, c)
+}
+
+func (aarch64Backend) maxArgs() int { return len(a64ArgRegs) }
+
+type riscvBackend struct{}
+
+func (riscvBackend) generate(prog *obj, w io.Writer, c *compilation) error {
+	return codegenRISCV(prog, w, c)
+}
+
+func (riscvBackend) maxArgs() int { return len(riscvArgRegs) }
+
+type wasmBackend struct{}
+
+func (wasmBackend) generate(prog *obj, w io.Writer, c *compilation) error {
+	return codegenWasm(prog, w)
+}
+
+// wasm の引数はスタックで渡るが、x86-64 と同じ数に揃える
+func (wasmBackend) maxArgs() int { return len(argregs64) }
+
+// targets はターゲットの名前（-target）ごとのコード生成
+var targets = map[string]backend{
+	"x86_64-linux":  x86Backend{},
+	"aarch64-linux": aarch64Backend{},
+	"riscv64-linux": riscvBackend{},
+	"wasm32-wasi":   wasmBackend{},
+}
+
+// lowerAndOptimize は型付き AST を IR に変換し、最適化レベルに応じたパスを走らせる
+func lowerAndOptimize(prog *obj, c *compilation) (*irProgram, error) {
+	ir, err := lower(prog)
+	if err != nil {
+		return nil, err
+	}
+	if err := optimize(ir, c.opts.OptLevel, c.opts.DumpPasses); err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
+
+// frameSize はローカル変数が使うフレームの大きさ（16 の倍数）を返す
+func frameSize(fn *obj) int {
+	size := 0
+	for v := fn.locals; v != nil; v = v.next {
+		size = max(size, v.offset)
+	}
+	return alignTo(size, 16)
+}
+
+// addressTaken は関数がローカル変数のアドレスを取るかどうかを返す
+func addressTaken(fn *obj) bool {
+	taken := false
+	walkNodes(fn.body, func(n *node) {
+		if n.kind == ndAddr && n.lhs.kind == ndVar && n.lhs.lvar.isLocal {
+			taken = true
+		}
+	})
+	return taken
+}
@@ -0,0 +1,53 @@
+#!/usr/bin/env bash
+# 生成コードを比べる。引数はコンパイラに渡すフラグの組（既定は "", -fpeephole, -fir-codegen, -O1, -O2）。
+#   - test.sh の各プログラムの命令数・メモリアクセス数・push/pop 数の合計
+#   - testdata/bench/*.c の実行時間
+tmpdir="${TMPDIR:-.tmp-work}"
+mkdir -p "$tmpdir"
+
+if [ $# -eq 0 ]; then
+    set -- "" "-fpeephole" "-fir-codegen" "-O1" "-O2"
+fi
+
+programs=()
+assert() {
+    programs+=("$2")
+}
+eval "$(grep '^assert ' test.sh)"
+
+# count は flags でコンパイルしたアセンブリの命令数などを数える
+count() {
+    local flags="$1" insts=0 mem=0 pushpop=0 s
+    for p in "${programs[@]}"; do
+        s=$(./g9cc $flags "$p" 2>/dev/null | grep -P '^\t' | grep -v '^\s*\.')
+        insts=$((insts + $(printf '%s\n' "$s" | wc -l)))
+        mem=$((mem + $(printf '%s\n' "$s" | grep -c '\[')))
+        pushpop=$((pushpop + $(printf '%s\n' "$s" | grep -cE '^\s*(push|pop) ')))
+    done
+    printf '%-16s %10d %10d %10d\n' "${flags:-(default)}" "$insts" "$mem" "$pushpop"
+}
+
+echo "static (${#programs[@]} programs from test.sh)"
+printf '%-16s %10s %10s %10s\n' flags insts memory push/pop
+for flags in "$@"; do
+    count "$flags"
+done
+
+echo
+echo "runtime (ms)"
+printf '%-16s' flags
+for src in testdata/bench/*.c; do
+    printf ' %10s' "$(basename "$src" .c)"
+done
+echo
+for flags in "$@"; do
+    printf '%-16s' "${flags:-(default)}"
+    for src in testdata/bench/*.c; do
+        ./g9cc $flags "$src" > "$tmpdir/bench.s" && gcc -o "$tmpdir/bench" "$tmpdir/bench.s" 2>/dev/null
+        start=$(date +%s%N)
+        "$tmpdir/bench"
+        end=$(date +%s%N)
+        printf ' %10d' $(((end - start) / 1000000))
+    done
+    echo
+done
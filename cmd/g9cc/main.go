@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/repunit11/g9cc"
+)
+
+const usage = "usage: g9cc [-o <output>] [-fmax-errors=<n>] [-Wall] [-W<name>] [-Wno-<name>] [-Werror] [-O<level>] [-emit-ir] [-emit-llvm] [-emit-c[=desugared]] [-ast-dump[=text|json|dot]] [-ast-dump-before-sema] [-fir-codegen] [-print-after-all] [-fpeephole] [-fno-peephole] [-fpeephole-stats] [-fPIC] [-g] [-fverbose-asm] [-masm=att|intel] [-target <triple>] <file.c | - | program>"
+
+// config はコマンドラインで指定された設定
+type config struct {
+	output string
+	input  string
+	opts   g9cc.Options
+}
+
+func main() {
+	cfg, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	filename, src, err := readInput(cfg.input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	asm, diags, err := g9cc.Compile(filename, src, cfg.opts)
+	for i := range diags {
+		fmt.Fprintln(os.Stderr, diags[i].Error())
+	}
+	if err != nil {
+		if !errors.Is(err, g9cc.ErrCompile) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+
+	if err := writeOutput(cfg.output, asm); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs はコマンドライン引数を解釈する
+func parseArgs(args []string) (*config, error) {
+	cfg := &config{}
+	hasInput := false
+	var peephole *bool // -fpeephole / -fno-peephole の指定（なければ -O1 以上で有効）
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-o":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing filename after -o")
+			}
+			i++
+			cfg.output = args[i]
+		case strings.HasPrefix(arg, "-fmax-errors="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "-fmax-errors="))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid argument: %s", arg)
+			}
+			cfg.opts.MaxErrors = n
+		case arg == "-Wall":
+			for _, name := range g9cc.WarningCategories() {
+				cfg.setWarning(name, true)
+			}
+		case arg == "-Werror":
+			cfg.opts.WarningsAsErrors = true
+		case arg == "-emit-ir":
+			cfg.opts.EmitIR = true
+		case arg == "-emit-llvm":
+			cfg.opts.EmitLLVM = true
+		case arg == "-ast-dump":
+			cfg.opts.ASTDump = "text"
+		case strings.HasPrefix(arg, "-ast-dump="):
+			cfg.opts.ASTDump = strings.TrimPrefix(arg, "-ast-dump=")
+		case arg == "-ast-dump-before-sema":
+			cfg.opts.ASTDumpBeforeSema = true
+		case arg == "-emit-c", arg == "-emit-c=desugared":
+			cfg.opts.EmitC = true
+			cfg.opts.Desugar = arg == "-emit-c=desugared"
+		case arg == "-fir-codegen":
+			cfg.opts.IRCodegen = true
+		case arg == "-print-after-all":
+			cfg.opts.DumpPasses = os.Stderr
+		case arg == "-fpeephole", arg == "-fno-peephole":
+			on := arg == "-fpeephole"
+			peephole = &on
+		case arg == "-fPIC", arg == "-fpic":
+			cfg.opts.PIC = true
+		case arg == "-fno-PIC", arg == "-fno-pic":
+			cfg.opts.PIC = false
+		case arg == "-g":
+			cfg.opts.Debug = true
+		case arg == "-target":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing target after -target")
+			}
+			i++
+			cfg.opts.Target = args[i]
+		case strings.HasPrefix(arg, "--target="):
+			cfg.opts.Target = strings.TrimPrefix(arg, "--target=")
+		case arg == "-fverbose-asm":
+			cfg.opts.VerboseAsm = true
+		case arg == "-masm=att", arg == "-masm=intel":
+			cfg.opts.ATTSyntax = arg == "-masm=att"
+		case strings.HasPrefix(arg, "-masm="):
+			return nil, fmt.Errorf("unknown assembler syntax: %s", arg)
+		case arg == "-fpeephole-stats":
+			cfg.opts.PeepholeStats = os.Stderr
+		case arg == "-O":
+			cfg.opts.OptLevel = 1
+		case strings.HasPrefix(arg, "-O"):
+			n, err := strconv.Atoi(arg[2:])
+			if err != nil || n < 0 || n > 2 {
+				return nil, fmt.Errorf("invalid optimization level: %s", arg)
+			}
+			cfg.opts.OptLevel = n
+		case strings.HasPrefix(arg, "-Wno-"):
+			if err := cfg.setWarning(strings.TrimPrefix(arg, "-Wno-"), false); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "-W") && len(arg) > 2:
+			if err := cfg.setWarning(arg[2:], true); err != nil {
+				return nil, err
+			}
+		case len(arg) > 2 && arg[:2] == "-o":
+			cfg.output = arg[2:]
+		default:
+			if hasInput {
+				return nil, fmt.Errorf("multiple inputs given")
+			}
+			cfg.input = arg
+			hasInput = true
+		}
+	}
+	if !hasInput {
+		return nil, fmt.Errorf("no input given")
+	}
+	cfg.opts.Peephole = cfg.opts.OptLevel > 0
+	if peephole != nil {
+		cfg.opts.Peephole = *peephole
+	}
+	return cfg, nil
+}
+
+// setWarning は警告カテゴリ name の有効・無効を設定する
+func (cfg *config) setWarning(name string, on bool) error {
+	if !g9cc.IsWarningCategory(name) {
+		return fmt.Errorf("unknown warning option: -W%s", name)
+	}
+	if cfg.opts.Warnings == nil {
+		cfg.opts.Warnings = map[string]bool{}
+	}
+	cfg.opts.Warnings[name] = on
+	return nil
+}
+
+// readInput は入力を読む。"-" は標準入力、".c" で終わる引数はファイル、
+// それ以外は引数そのものをプログラムとして扱う。
+func readInput(input string) (string, []byte, error) {
+	switch {
+	case input == "-":
+		src, err := io.ReadAll(os.Stdin)
+		return "<stdin>", src, err
+	case strings.HasSuffix(input, ".c"):
+		src, err := os.ReadFile(input)
+		return input, src, err
+	}
+	return "<command-line>", []byte(input), nil
+}
+
+// writeOutput は出力先（空または "-" なら標準出力）に data を書き込む
+func writeOutput(path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
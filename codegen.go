@@ -1,293 +1,519 @@
-package main
+package g9cc
 
 import (
+	"bufio"
 	"fmt"
-	"os"
+	"io"
+	"slices"
+	"strings"
 )
 
-var cntif int
 var argregs64 = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
 var argregs32 = []string{"edi", "esi", "edx", "ecx", "r8d", "r9d"}
 var argregs8 = []string{"dil", "sil", "dl", "cl", "r8b", "r9b"}
 
-func count() int {
-	cntif++
-	return cntif
+// generator は1回のコンパイル分のコード生成状態を保持する
+type generator struct {
+	w        *bufio.Writer
+	cntif    int        // ラベル番号
+	insts    []*asmInst // 出力する命令の列（asm.go）
+	peephole bool       // 書き出す前に peephole 最適化をかける
+	stats    map[string]int
+	statsOut io.Writer       // nil でなければ peephole の統計を書く
+	pic      bool            // 位置独立コードを出す（-fPIC）
+	syntax   asmSyntax       // 出力の記法（-masm）
+	statics  map[string]bool // static な関数の名前
+	src      *source         // 入力（行番号とソースの表示に使う）
+	dbg      *debugInfo      // nil でなければデバッグ情報を出す（-g、debug.go）
+	verbose  *verboseInfo    // nil でなければソースを注釈するコメントを出す（-fverbose-asm、verbose.go）
 }
 
-func load(ty *ty) {
+// source は入力とその各行の先頭のオフセット
+type source struct {
+	filename   string
+	input      string
+	lineStarts []int
+}
+
+func newSource(filename, input string) *source {
+	s := &source{filename: filename, input: input, lineStarts: []int{0}}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			s.lineStarts = append(s.lineStarts, i+1)
+		}
+	}
+	return s
+}
+
+// position はトークンの行と桁（1 始まり）を返す
+func (s *source) position(tok *token) (line, col int) {
+	line, found := slices.BinarySearch(s.lineStarts, tok.pos)
+	if !found {
+		line--
+	}
+	return line + 1, tok.pos - s.lineStarts[line] + 1
+}
+
+// line は line 行目（1 始まり）の内容を返す
+func (s *source) line(line int) string {
+	end := len(s.input)
+	if line < len(s.lineStarts) {
+		end = s.lineStarts[line] - 1
+	}
+	return s.input[s.lineStarts[line-1]:end]
+}
+
+// globalAddr はグローバル変数 v のアドレスをレジスタ reg に求める。
+// -fPIC では static でない変数は他のモジュールにあるかもしれないので GOT から読む。
+func (g *generator) globalAddr(reg string, v *obj) {
+	if g.pic && !v.isStatic && !isStringLiteral(v) {
+		g.emit("mov", regOp(reg), ripOp(*v.name+"@GOTPCREL").ptr(8))
+		return
+	}
+	g.emit("lea", regOp(reg), ripOp(*v.name))
+}
+
+// callTarget は関数 name の呼び出し先を返す。-fPIC では static でない関数を PLT 経由で呼ぶ。
+func (g *generator) callTarget(name string) asmOperand {
+	if g.pic && !g.statics[name] {
+		return symOp(name + "@PLT")
+	}
+	return symOp(name)
+}
+
+func (g *generator) count() int {
+	g.cntif++
+	return g.cntif
+}
+
+func (g *generator) load(ty *ty) {
 	if ty.kind == tyArray {
 		return
 	}
 	if ty.size == 8 {
-		fmt.Printf("	mov rax, [rax]\n")
+		g.emit("mov", regOp("rax"), memOp("rax", 0))
 	} else if ty.size == 4 {
-		fmt.Printf("	mov eax, [rax]\n")
+		g.emit("mov", regOp("eax"), memOp("rax", 0))
 	} else if ty.size == 1 {
-		fmt.Printf("	movsbq rax, [rax]\n")
+		g.emit("movsx", regOp("rax"), memOp("rax", 0).ptr(1))
 	}
 }
 
-func store(ty *ty) {
-	fmt.Printf("	pop rax\n")
+func (g *generator) store(ty *ty) {
+	g.emit("pop", regOp("rax"))
 	if ty.size == 8 {
-		fmt.Printf("	mov [rax], rdi\n")
+		g.emit("mov", memOp("rax", 0), regOp("rdi"))
 	} else if ty.size == 4 {
-		fmt.Printf("	mov [rax], edi\n")
+		g.emit("mov", memOp("rax", 0), regOp("edi"))
 	} else if ty.size == 1 {
-		fmt.Printf("	mov [rax], dil\n")
+		g.emit("mov", memOp("rax", 0), regOp("dil"))
 	}
 }
 
-func genExpr(node *node) {
+func (g *generator) genExpr(node *node) error {
 	switch node.kind {
 	case ndNum:
-		fmt.Printf("	push %d\n", node.val)
-		return
+		g.emit("push", immOp(node.val))
+		return nil
 	case ndVar:
-		genAddr(node)
-		fmt.Printf("	pop rax\n")
-		load(node.ty)
-		fmt.Printf("	push rax\n")
-		return
+		if err := g.genAddr(node); err != nil {
+			return err
+		}
+		g.emit("pop", regOp("rax"))
+		g.load(node.ty)
+		g.emit("push", regOp("rax"))
+		return nil
 	case ndAssign:
-		genAddr(node.lhs)
-		genExpr(node.rhs)
-		fmt.Printf("	pop rdi\n")
-		store(node.lhs.ty)
-		fmt.Printf("	push rdi\n")
-		return
-	case ndFuncall: // TODO: 関数呼び出し前にRSPを16の倍数になるようにする
-		for i := len(node.args) - 1; i >= 0; i-- {
-			genExpr(node.args[i])
+		if err := g.genAddr(node.lhs); err != nil {
+			return err
+		}
+		if err := g.genExpr(node.rhs); err != nil {
+			return err
 		}
+		g.emit("pop", regOp("rdi"))
+		g.store(node.lhs.ty)
+		g.emit("push", regOp("rdi"))
+		return nil
+	case ndFuncall: // TODO: 関数呼び出し前にRSPを16の倍数になるようにする
+		// 引数の個数は sema で検査済み
 		if len(node.args) > len(argregs64) {
-			fmt.Fprintf(os.Stderr, "too many arguments: max %d\n", len(argregs64))
-			os.Exit(1)
+			return fmt.Errorf("internal error: too many arguments: %d", len(node.args))
+		}
+		for i := len(node.args) - 1; i >= 0; i-- {
+			if err := g.genExpr(node.args[i]); err != nil {
+				return err
+			}
 		}
 		for i := 0; i < len(node.args); i++ {
-			fmt.Printf("	pop %s\n", argregs64[i])
+			g.emit("pop", regOp(argregs64[i]))
 		}
-		fmt.Printf("	call %s\n", node.funcname)
-		fmt.Printf("	push rax\n")
-		return
+		g.emit("call", g.callTarget(node.funcname))
+		g.emit("push", regOp("rax"))
+		return nil
 	case ndAddr:
-		genAddr(node.lhs)
-		return
+		return g.genAddr(node.lhs)
 	case ndDeref:
-		genExpr(node.lhs)
-		fmt.Printf("	pop rax\n")
-		load(node.ty)
-		fmt.Printf("	push rax\n")
-		return
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.emit("pop", regOp("rax"))
+		g.load(node.ty)
+		g.emit("push", regOp("rax"))
+		return nil
 	}
 
-	genExpr(node.lhs)
-	genExpr(node.rhs)
+	if err := g.genExpr(node.lhs); err != nil {
+		return err
+	}
+	if err := g.genExpr(node.rhs); err != nil {
+		return err
+	}
 
-	fmt.Printf("	pop rdi\n")
-	fmt.Printf("	pop rax\n")
+	g.emit("pop", regOp("rdi"))
+	g.emit("pop", regOp("rax"))
 
+	rax, rdi := regOp("rax"), regOp("rdi")
 	switch node.kind {
 	case ndAdd:
-		fmt.Printf("	add rax, rdi\n")
+		g.emit("add", rax, rdi)
 		break
 	case ndSub:
-		fmt.Printf("	sub rax, rdi\n")
+		g.emit("sub", rax, rdi)
 		break
 	case ndMul:
-		fmt.Printf("	imul rax, rdi\n")
+		g.emit("imul", rax, rdi)
 		break
 	case ndDiv:
-		fmt.Printf("	cqo\n")
-		fmt.Printf("	idiv rdi\n")
+		g.emit("cqo")
+		g.emit("idiv", rdi)
 		break
 	case ndEq:
-		fmt.Printf("	cmp rax, rdi\n")
-		fmt.Printf("	sete al\n")
-		fmt.Printf("	movzb rax, al\n")
+		g.emit("cmp", rax, rdi)
+		g.emit("sete", regOp("al"))
+		g.emit("movzx", rax, regOp("al"))
 		break
 	case ndNe:
-		fmt.Printf("	cmp rax, rdi\n")
-		fmt.Printf("	setne al\n")
-		fmt.Printf("	movzb rax, al\n")
+		g.emit("cmp", rax, rdi)
+		g.emit("setne", regOp("al"))
+		g.emit("movzx", rax, regOp("al"))
 		break
 	case ndLt:
-		fmt.Printf("	cmp rax, rdi\n")
-		fmt.Printf("	setl al\n")
-		fmt.Printf("	movzb rax, al\n")
+		g.emit("cmp", rax, rdi)
+		g.emit("setl", regOp("al"))
+		g.emit("movzx", rax, regOp("al"))
 		break
 	case ndLe:
-		fmt.Printf("	cmp rax, rdi\n")
-		fmt.Printf("	setle al\n")
-		fmt.Printf("	movzb rax, al\n")
+		g.emit("cmp", rax, rdi)
+		g.emit("setle", regOp("al"))
+		g.emit("movzx", rax, regOp("al"))
 		break
 	default:
-		fmt.Fprintf(os.Stderr, "unexpected node kind")
-		os.Exit(1)
+		return fmt.Errorf("internal error: unexpected node kind: %d", node.kind)
 	}
-	fmt.Printf("	push rax\n")
+	g.emit("push", regOp("rax"))
+	return nil
 }
 
 // 文のコード生成
-func genStmt(node *node) {
+func (g *generator) genStmt(node *node) error {
+	if node.kind != ndBlock {
+		g.loc(node.tok)
+		g.commentStmt(node)
+	}
 	switch node.kind {
 	case ndExprStmt:
-		genExpr(node.lhs)
-		fmt.Printf("	pop rax\n")
-		return
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.emit("pop", regOp("rax"))
+		return nil
 	case ndReturn:
-		genExpr(node.lhs)
-		fmt.Printf("	pop rax\n")
-		fmt.Printf("	mov rsp, rbp\n")
-		fmt.Printf("	pop rbp\n")
-		fmt.Printf("	ret\n")
-		return
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.emit("pop", regOp("rax"))
+		g.ret()
+		return nil
 	case ndIf:
-		cnt := count()
-		genExpr(node.cond)
-		fmt.Printf("	pop rax\n")
-		fmt.Printf("	cmp rax, 0\n")
-		fmt.Printf("	je .Lelse%d\n", cnt)
-		genStmt(node.then)
-		fmt.Printf("	jmp .Lend%d\n", cnt)
-		fmt.Printf(".Lelse%d:\n", cnt)
+		cnt := g.count()
+		if err := g.genExpr(node.cond); err != nil {
+			return err
+		}
+		g.emit("pop", regOp("rax"))
+		g.emit("cmp", regOp("rax"), immOp(0))
+		g.emit("je", symOp(fmt.Sprintf(".Lelse%d", cnt)))
+		if err := g.genStmt(node.then); err != nil {
+			return err
+		}
+		g.emit("jmp", symOp(fmt.Sprintf(".Lend%d", cnt)))
+		g.markedLabel("else of "+g.stmtText(node), ".Lelse%d", cnt)
 		if node.els != nil {
-			genStmt(node.els)
+			if err := g.genStmt(node.els); err != nil {
+				return err
+			}
 		}
-		fmt.Printf(".Lend%d:\n", cnt)
-		return
+		g.markedLabel("end of "+g.stmtText(node), ".Lend%d", cnt)
+		return nil
 	case ndWhile:
-		cnt := count()
-		fmt.Printf(".Lbegin%d:\n", cnt)
-		genExpr(node.lhs)
-		fmt.Printf("	pop rax\n")
-		fmt.Printf("	cmp rax, 0\n")
-		fmt.Printf("	je	.Lend%d\n", cnt)
-		genStmt(node.rhs)
-		fmt.Printf("	jmp	.Lbegin%d\n", cnt)
-		fmt.Printf(".Lend%d:\n", cnt)
-		return
+		cnt := g.count()
+		g.markedLabel("begin of "+g.stmtText(node), ".Lbegin%d", cnt)
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.emit("pop", regOp("rax"))
+		g.emit("cmp", regOp("rax"), immOp(0))
+		g.emit("je", symOp(fmt.Sprintf(".Lend%d", cnt)))
+		if err := g.genStmt(node.rhs); err != nil {
+			return err
+		}
+		g.emit("jmp", symOp(fmt.Sprintf(".Lbegin%d", cnt)))
+		g.markedLabel("end of "+g.stmtText(node), ".Lend%d", cnt)
+		return nil
 	case ndFor:
-		cnt := count()
+		cnt := g.count()
 		if node.init != nil {
-			genExpr(node.init)
-			fmt.Printf("	pop rax\n")
+			if err := g.genExpr(node.init); err != nil {
+				return err
+			}
+			g.emit("pop", regOp("rax"))
 		}
-		fmt.Printf(".Lbegin%d:\n", cnt)
+		g.markedLabel("begin of "+g.stmtText(node), ".Lbegin%d", cnt)
 		if node.cond != nil {
-			genExpr(node.cond)
-			fmt.Printf("	pop rax\n")
-			fmt.Printf("	cmp rax, 0\n")
-			fmt.Printf("	je .Lend%d\n", cnt)
+			if err := g.genExpr(node.cond); err != nil {
+				return err
+			}
+			g.emit("pop", regOp("rax"))
+			g.emit("cmp", regOp("rax"), immOp(0))
+			g.emit("je", symOp(fmt.Sprintf(".Lend%d", cnt)))
 		}
 		if node.then != nil {
-			genStmt(node.then)
+			if err := g.genStmt(node.then); err != nil {
+				return err
+			}
 		}
 		if node.inc != nil {
-			genExpr(node.inc)
-			fmt.Printf("	pop rax\n")
+			if err := g.genExpr(node.inc); err != nil {
+				return err
+			}
+			g.emit("pop", regOp("rax"))
 		}
-		fmt.Printf("	jmp .Lbegin%d\n", cnt)
-		fmt.Printf(".Lend%d:\n", cnt)
-		return
+		g.emit("jmp", symOp(fmt.Sprintf(".Lbegin%d", cnt)))
+		g.markedLabel("end of "+g.stmtText(node), ".Lend%d", cnt)
+		return nil
 	case ndBlock:
 		n := node.lhs
 		for n != nil {
-			genStmt(n)
+			if err := g.genStmt(n); err != nil {
+				return err
+			}
 			n = n.next
 		}
-		return
+		return nil
 	default:
-		fmt.Fprintf(os.Stderr, "invalid statement")
-		os.Exit(1)
+		return fmt.Errorf("internal error: invalid statement: %d", node.kind)
 	}
 }
 
-func genFunc(funct *obj) {
-	fmt.Printf("%s:\n", *funct.name)
+func (g *generator) genFunc(funct *obj) error {
+	g.funcStart(funct)
 
 	// プロローグ
-	fmt.Printf("	push rbp\n")
-	fmt.Printf("	mov rbp, rsp\n")
-	fmt.Printf("	sub rsp, 208\n") // 208 = ('z' - 'a' + 1) * 8
+	g.pushFrame()
+	g.emit("sub", regOp("rsp"), immOp(208)) // 208 = ('z' - 'a' + 1) * 8
 
 	param := funct.params
 	i := 0
 	for param != nil {
 		if param.ty.size == 4 {
-			fmt.Printf("	mov [rbp - %d], %s\n", param.offset, argregs32[i])
+			g.emit("mov", memOp("rbp", -param.offset), regOp(argregs32[i]))
 		} else if param.ty.size == 8 {
-			fmt.Printf("	mov [rbp - %d], %s\n", param.offset, argregs64[i])
+			g.emit("mov", memOp("rbp", -param.offset), regOp(argregs64[i]))
 		} else if param.ty.size == 1 {
-			fmt.Printf("	mov [rbp - %d], %s\n", param.offset, argregs8[i])
+			g.emit("mov", memOp("rbp", -param.offset), regOp(argregs8[i]))
 		}
 		param = param.next
 		i++
 	}
 
 	// ASTの生成
-	genStmt(funct.body)
+	if err := g.genStmt(funct.body); err != nil {
+		return err
+	}
+
+	if funct.returnsZero {
+		g.emit("mov", regOp("rax"), immOp(0))
+	}
+	g.ret()
+	g.funcEnd(funct)
+	return nil
+}
 
-	fmt.Printf("	mov rsp, rbp\n")
-	fmt.Printf("	pop rbp\n")
-	fmt.Printf("	ret\n")
+// ret はフレームを畳んで戻る。後ろに続くコードの CFI のために状態を保存・復元しておく。
+func (g *generator) ret() {
+	g.comment("epilogue")
+	g.debugDirective(".cfi_remember_state")
+	g.popFrame()
+	g.emit("ret")
+	g.debugDirective(".cfi_restore_state")
 }
 
 // 左辺値のアドレス生成
-func genAddr(node *node) {
+func (g *generator) genAddr(node *node) error {
 	switch node.kind {
 	case ndVar:
 		if node.lvar.isLocal {
 			offset := node.lvar.offset
-			fmt.Printf("	mov rax, rbp\n")
-			fmt.Printf("	sub rax, %d\n", offset)
-			fmt.Printf("	push rax\n")
+			g.commentLocal(node.lvar)
+			g.emit("mov", regOp("rax"), regOp("rbp"))
+			g.emit("sub", regOp("rax"), immOp(offset))
+			g.emit("push", regOp("rax"))
 		} else {
-			fmt.Printf("	lea rax, %s[rip]\n", *node.lvar.name)
-			fmt.Printf("	push rax\n")
+			g.globalAddr("rax", node.lvar)
+			g.emit("push", regOp("rax"))
 		}
-		return
+		return nil
 	case ndDeref:
-		genExpr(node.lhs)
-		return
+		return g.genExpr(node.lhs)
 	}
 
-	fmt.Fprintf(os.Stderr, "not an lvalue")
+	// 左辺値の検査は sema で済んでいる
+	return fmt.Errorf("internal error: not an lvalue: %d", node.kind)
 }
 
-func emitData(prog *obj) {
-	fmt.Printf(".data\n")
+// emitData はグローバル変数を出力する。
+// 文字列リテラルと const の変数は .rodata、初期値が 0 でなければ .data、
+// 0 で初期化したものは .bss、初期値のないもの（仮定義）は .comm に置く。
+func (g *generator) emitData(prog *obj) {
+	section := ""
 	for v := prog; v != nil; v = v.next {
-		if v.isFunction {
+		if v.isFunction || v.isExtern {
 			continue
 		}
-		fmt.Printf(".global %s\n", *v.name)
-		fmt.Printf("%s:\n", *v.name)
-		if v.initData != nil {
-			data := *v.initData
-			for i := 0; i < len(data); i++ {
-				fmt.Printf("    .byte %d\n", data[i])
+		align := globalAlign(v.ty)
+		if v.initData == nil && !v.ty.readOnly() {
+			if v.isStatic {
+				g.directive(".local %s", *v.name)
 			}
-		} else {
-			fmt.Printf("    .zero %d\n", v.ty.size)
+			g.directive(".comm %s, %d, %d", *v.name, v.ty.size, align)
+			continue
+		}
+
+		s := dataSection(v)
+		if s != section {
+			g.directive("%s", s)
+			section = s
 		}
+		// static な変数と文字列リテラルはファイルの外に見せない
+		if !v.isStatic && !isStringLiteral(v) {
+			g.directive(".global %s", *v.name)
+		}
+		g.directive("    .balign %d", align) // .align はターゲットによってはバイト数でなく 2 の累乗
+		if !isStringLiteral(v) {
+			// 共有ライブラリの変数はコピー再配置のために型と大きさが要る
+			g.directive(".type %s, @object", *v.name)
+			g.directive(".size %s, %d", *v.name, v.ty.size)
+		}
+		g.label("%s", *v.name)
+		if s == ".bss" || v.initData == nil {
+			g.directive("    .zero %d", v.ty.size)
+			continue
+		}
+		data := *v.initData
+		for i := 0; i < len(data); i++ {
+			g.directive("    .byte %d", data[i])
+		}
+	}
+}
+
+// dataSection は初期値を持つ（または const の）グローバル変数を置くセクションを返す
+func dataSection(v *obj) string {
+	if v.ty.readOnly() || isStringLiteral(v) {
+		return ".section .rodata"
+	}
+	if v.initData != nil && strings.Trim(*v.initData, "\x00") != "" {
+		return ".data"
+	}
+	return ".bss"
+}
+
+// isStringLiteral は v が文字列リテラル（newAnonStringLiteral の .L..N）かどうかを返す
+func isStringLiteral(v *obj) bool {
+	return strings.HasPrefix(*v.name, ".L..")
+}
+
+// globalAlign はグローバル変数のアラインメントを返す。
+// x86-64 の ABI に合わせ、16 バイト以上の配列は 16 バイトにそろえる。
+func globalAlign(t *ty) int {
+	if t.kind == tyArray && t.size >= 16 {
+		return 16
+	}
+	return t.align()
+}
+
+// textSection は .text に切り替える。Intel 記法ならここから記法を切り替える（データは記法によらない）。
+func (g *generator) textSection() {
+	if g.syntax == syntaxIntel {
+		g.directive(".intel_syntax noprefix")
+	}
+	g.directive(".text")
+	if g.dbg != nil {
+		g.label(".Ltext0")
 	}
 }
 
-func emitText(prog *obj) {
-	fmt.Printf(".intel_syntax noprefix\n")
-	fmt.Printf(".text\n")
+func (g *generator) emitText(prog *obj) error {
+	g.textSection()
 	for v := prog; v != nil; v = v.next {
 		if !v.isFunction {
 			continue
 		}
-		fmt.Printf(".global %s\n", *v.name)
-		genFunc(v)
+		if !v.isStatic {
+			g.directive(".global %s", *v.name)
+		}
+		g.directive(".type %s, @function", *v.name)
+		if err := g.genFunc(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func codegen(prog *obj, w io.Writer, c *compilation) error {
+	g := newGenerator(w, prog, c)
+	g.fileDirective()
+	g.emitData(prog)
+	if err := g.emitText(prog); err != nil {
+		return err
 	}
+	g.emitDebugInfo(prog)
+	return g.flush()
 }
 
-func codegen(prog *obj) {
-	emitData(prog)
-	emitText(prog)
+func newGenerator(w io.Writer, prog *obj, c *compilation) *generator {
+	opts := &c.opts
+	g := &generator{
+		w:        bufio.NewWriter(w),
+		peephole: opts.Peephole,
+		stats:    map[string]int{},
+		statsOut: opts.PeepholeStats,
+		pic:      opts.PIC,
+		syntax:   syntaxIntel,
+		statics:  map[string]bool{},
+		src:      newSource(c.filename, c.input),
+	}
+	for v := prog; v != nil; v = v.next {
+		if v.isFunction && v.isStatic {
+			g.statics[*v.name] = true
+		}
+	}
+	if opts.ATTSyntax {
+		g.syntax = syntaxATT
+	}
+	if opts.Debug {
+		g.dbg = &debugInfo{types: map[string]int{}}
+	}
+	if opts.VerboseAsm {
+		g.verbose = &verboseInfo{}
+	}
+	return g
 }
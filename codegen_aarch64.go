@@ -0,0 +1,354 @@
+package g9cc
+
+import (
+	"fmt"
+	"io"
+)
+
+// AArch64（AAPCS64）向けのコード生成（-target aarch64-linux）。
+// x86-64 の codegen.go と同じくスタックマシンとして式を計算する。
+// x0 が計算結果、x1 が二項演算の右辺で、スタックへの push/pop は sp を 16 バイトにそろえたまま
+// 1 つの値に 16 バイトを使う。フレームは x29 を基準にし、ローカル変数は [x29 - offset] に置く。
+
+var a64ArgRegs = []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"}
+
+// a64Generator は AArch64 のコード生成の状態。データの出力と書き出しは generator を使う。
+type a64Generator struct {
+	*generator
+}
+
+// ins は命令を 1 行積む
+func (g *a64Generator) ins(format string, args ...any) {
+	g.directive("\t"+format, args...)
+}
+
+func (g *a64Generator) push() {
+	g.ins("str x0, [sp, #-16]!")
+}
+
+func (g *a64Generator) pop(reg string) {
+	g.ins("ldr %s, [sp], #16", reg)
+}
+
+// imm は 64 ビットの即値 v を reg に置く
+func (g *a64Generator) imm(reg string, v int) {
+	if -65536 < v && v < 65536 {
+		g.ins("mov %s, #%d", reg, v)
+		return
+	}
+	g.ins("movz %s, #%d", reg, uint64(v)&0xffff)
+	for shift := 16; shift < 64; shift += 16 {
+		if part := uint64(v) >> shift & 0xffff; part != 0 {
+			g.ins("movk %s, #%d, lsl #%d", reg, part, shift)
+		}
+	}
+}
+
+// frameAddr は reg に x29 - offset を求める
+func (g *a64Generator) frameAddr(reg string, offset int) {
+	if offset < 4096 {
+		g.ins("sub %s, x29, #%d", reg, offset)
+		return
+	}
+	g.imm("x9", offset)
+	g.ins("sub %s, x29, x9", reg)
+}
+
+// globalAddr は x0 にグローバル変数 v のアドレスを求める。
+// -fPIC では static でない変数のアドレスを GOT から読む。
+func (g *a64Generator) globalAddr(v *obj) {
+	if g.pic && !v.isStatic && !isStringLiteral(v) {
+		g.ins("adrp x0, :got:%s", *v.name)
+		g.ins("ldr x0, [x0, :got_lo12:%s]", *v.name)
+		return
+	}
+	g.ins("adrp x0, %s", *v.name)
+	g.ins("add x0, x0, :lo12:%s", *v.name)
+}
+
+// load は x0 が指す ty 型の値を x0 に読む（int と char は符号拡張する）
+func (g *a64Generator) load(ty *ty) {
+	switch {
+	case ty.kind == tyArray:
+	case ty.size == 8:
+		g.ins("ldr x0, [x0]")
+	case ty.size == 4:
+		g.ins("ldrsw x0, [x0]")
+	case ty.size == 1:
+		g.ins("ldrsb x0, [x0]")
+	}
+}
+
+// store は x0 の値を x1 が指す ty 型の場所に書く
+func (g *a64Generator) store(ty *ty) {
+	switch ty.size {
+	case 8:
+		g.ins("str x0, [x1]")
+	case 4:
+		g.ins("str w0, [x1]")
+	case 1:
+		g.ins("strb w0, [x1]")
+	}
+}
+
+var a64Conds = map[nodeKind]string{ndEq: "eq", ndNe: "ne", ndLt: "lt", ndLe: "le"}
+
+func (g *a64Generator) genExpr(node *node) error {
+	switch node.kind {
+	case ndNum:
+		g.imm("x0", node.val)
+		g.push()
+		return nil
+	case ndVar, ndDeref:
+		if node.kind == ndVar {
+			if err := g.genAddr(node); err != nil {
+				return err
+			}
+		} else if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("x0")
+		g.load(node.ty)
+		g.push()
+		return nil
+	case ndAssign:
+		if err := g.genAddr(node.lhs); err != nil {
+			return err
+		}
+		if err := g.genExpr(node.rhs); err != nil {
+			return err
+		}
+		g.pop("x0")
+		g.pop("x1")
+		g.store(node.lhs.ty)
+		g.push()
+		return nil
+	case ndFuncall:
+		// 引数の個数は sema で検査済み
+		if len(node.args) > len(a64ArgRegs) {
+			return fmt.Errorf("internal error: too many arguments: %d", len(node.args))
+		}
+		for i := len(node.args) - 1; i >= 0; i-- {
+			if err := g.genExpr(node.args[i]); err != nil {
+				return err
+			}
+		}
+		for i := range node.args {
+			g.pop(a64ArgRegs[i])
+		}
+		g.ins("bl %s", node.funcname)
+		// AAPCS64 では int や char の戻り値の上位ビットは不定
+		switch node.ty.size {
+		case 4:
+			g.ins("sxtw x0, w0")
+		case 1:
+			g.ins("sxtb x0, w0")
+		}
+		g.push()
+		return nil
+	case ndAddr:
+		return g.genAddr(node.lhs)
+	}
+
+	if err := g.genExpr(node.lhs); err != nil {
+		return err
+	}
+	if err := g.genExpr(node.rhs); err != nil {
+		return err
+	}
+	g.pop("x1")
+	g.pop("x0")
+
+	switch node.kind {
+	case ndAdd:
+		g.ins("add x0, x0, x1")
+	case ndSub:
+		g.ins("sub x0, x0, x1")
+	case ndMul:
+		g.ins("mul x0, x0, x1")
+	case ndDiv:
+		g.ins("sdiv x0, x0, x1")
+	case ndEq, ndNe, ndLt, ndLe:
+		g.ins("cmp x0, x1")
+		g.ins("cset x0, %s", a64Conds[node.kind])
+	default:
+		return fmt.Errorf("internal error: unexpected node kind: %d", node.kind)
+	}
+	g.push()
+	return nil
+}
+
+// genAddr は左辺値のアドレスを push する
+func (g *a64Generator) genAddr(node *node) error {
+	switch node.kind {
+	case ndVar:
+		if node.lvar.isLocal {
+			g.frameAddr("x0", node.lvar.offset)
+		} else {
+			g.globalAddr(node.lvar)
+		}
+		g.push()
+		return nil
+	case ndDeref:
+		return g.genExpr(node.lhs)
+	}
+
+	// 左辺値の検査は sema で済んでいる
+	return fmt.Errorf("internal error: not an lvalue: %d", node.kind)
+}
+
+func (g *a64Generator) genStmt(node *node) error {
+	switch node.kind {
+	case ndExprStmt:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("x0")
+		return nil
+	case ndReturn:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("x0")
+		g.epilogue()
+		return nil
+	case ndIf:
+		cnt := g.count()
+		if err := g.genExpr(node.cond); err != nil {
+			return err
+		}
+		g.pop("x0")
+		g.ins("cbz x0, .Lelse%d", cnt)
+		if err := g.genStmt(node.then); err != nil {
+			return err
+		}
+		g.ins("b .Lend%d", cnt)
+		g.label(".Lelse%d", cnt)
+		if node.els != nil {
+			if err := g.genStmt(node.els); err != nil {
+				return err
+			}
+		}
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndWhile:
+		cnt := g.count()
+		g.label(".Lbegin%d", cnt)
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("x0")
+		g.ins("cbz x0, .Lend%d", cnt)
+		if err := g.genStmt(node.rhs); err != nil {
+			return err
+		}
+		g.ins("b .Lbegin%d", cnt)
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndFor:
+		cnt := g.count()
+		if node.init != nil {
+			if err := g.genExpr(node.init); err != nil {
+				return err
+			}
+			g.pop("x0")
+		}
+		g.label(".Lbegin%d", cnt)
+		if node.cond != nil {
+			if err := g.genExpr(node.cond); err != nil {
+				return err
+			}
+			g.pop("x0")
+			g.ins("cbz x0, .Lend%d", cnt)
+		}
+		if node.then != nil {
+			if err := g.genStmt(node.then); err != nil {
+				return err
+			}
+		}
+		if node.inc != nil {
+			if err := g.genExpr(node.inc); err != nil {
+				return err
+			}
+			g.pop("x0")
+		}
+		g.ins("b .Lbegin%d", cnt)
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndBlock:
+		for n := node.lhs; n != nil; n = n.next {
+			if err := g.genStmt(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("internal error: invalid statement: %d", node.kind)
+	}
+}
+
+// epilogue はフレームを畳んで戻る（戻り値は x0）
+func (g *a64Generator) epilogue() {
+	g.ins("mov sp, x29")
+	g.ins("ldp x29, x30, [sp], #16")
+	g.ins("ret")
+}
+
+func (g *a64Generator) genFunc(fn *obj) error {
+	if !fn.isStatic {
+		g.directive(".global %s", *fn.name)
+	}
+	g.directive(".type %s, @function", *fn.name)
+	g.label("%s", *fn.name)
+
+	// プロローグ。x29（フレームポインタ）と x30（戻りアドレス）を保存する
+	g.ins("stp x29, x30, [sp, #-16]!")
+	g.ins("mov x29, sp")
+	if size := frameSize(fn); size > 0 {
+		if size < 4096 {
+			g.ins("sub sp, sp, #%d", size)
+		} else {
+			g.imm("x9", size)
+			g.ins("sub sp, sp, x9")
+		}
+	}
+	i := 0
+	for param := fn.params; param != nil; param = param.next {
+		g.frameAddr("x9", param.offset)
+		switch param.ty.size {
+		case 8:
+			g.ins("str %s, [x9]", a64ArgRegs[i])
+		case 4:
+			g.ins("str w%s, [x9]", a64ArgRegs[i][1:])
+		case 1:
+			g.ins("strb w%s, [x9]", a64ArgRegs[i][1:])
+		}
+		i++
+	}
+
+	if err := g.genStmt(fn.body); err != nil {
+		return err
+	}
+	if fn.returnsZero {
+		g.imm("x0", 0)
+	}
+	g.epilogue()
+	g.directive(".size %s, .-%s", *fn.name, *fn.name)
+	return nil
+}
+
+// codegenAArch64 は型付き AST から AArch64 のアセンブリを生成する
+func codegenAArch64(prog *obj, w io.Writer, c *compilation) error {
+	g := &a64Generator{generator: newGenerator(w, prog, c)}
+	g.emitData(prog)
+	g.directive(".text")
+	for v := prog; v != nil; v = v.next {
+		if !v.isFunction {
+			continue
+		}
+		if err := g.genFunc(v); err != nil {
+			return err
+		}
+	}
+	return g.flush()
+}
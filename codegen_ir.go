@@ -0,0 +1,332 @@
+package g9cc
+
+import (
+	"fmt"
+	"io"
+)
+
+// IR から x86-64 アセンブリを生成する。
+// 仮想レジスタは regalloc.go で割り当てた x86 のレジスタに置き、
+// spill したものだけフレーム上の 8 バイトのスロットに置く。
+// rax, rdx, r11 は命令を展開するときの作業用レジスタ。
+
+// irFrame は関数のスタックフレームの配置
+type irFrame struct {
+	ra        *regAlloc
+	slots     []int          // spill した仮想レジスタのスロットの rbp からのオフセット
+	saved     map[string]int // 退避した callee-saved レジスタのスロット
+	stackSize int
+}
+
+func newIRFrame(f *irFunc) *irFrame {
+	ra := allocRegs(f)
+
+	// ローカル変数、callee-saved レジスタの退避場所、spill スロットの順に並べる
+	size := 0
+	for v := f.fn.locals; v != nil; v = v.next {
+		size = max(size, v.offset)
+	}
+	size = alignTo(size, 8)
+	fr := &irFrame{ra: ra, slots: make([]int, len(f.types)), saved: map[string]int{}}
+	for _, reg := range ra.usedCallee {
+		size += 8
+		fr.saved[reg] = size
+	}
+	for r := 1; r < len(f.types); r++ {
+		if ra.spilled[r] {
+			size += 8
+			fr.slots[r] = size
+		}
+	}
+	fr.stackSize = alignTo(size, 16)
+	return fr
+}
+
+// loc は仮想レジスタ r の置き場所（レジスタかスロット）を返す
+func (fr *irFrame) loc(r int) asmOperand {
+	if reg := fr.ra.regs[r]; reg != "" {
+		return regOp(reg)
+	}
+	return memOp("rbp", -fr.slots[r]).ptr(8)
+}
+
+func (g *generator) irLabel(f *irFunc, b *irBlock) string {
+	return fmt.Sprintf(".L.%s.bb%d", f.name(), b.id)
+}
+
+// use は仮想レジスタ r の値が入った x86 のレジスタを返す。
+// spill していれば作業用レジスタ scratch に読み出す。
+func (g *generator) use(fr *irFrame, r int, scratch string) string {
+	if reg := fr.ra.regs[r]; reg != "" {
+		return reg
+	}
+	g.emit("mov", regOp(scratch), fr.loc(r))
+	return scratch
+}
+
+// def は仮想レジスタ r の結果を計算するレジスタを返す。spill していれば rax。
+func (fr *irFrame) def(r int) string {
+	if reg := fr.ra.regs[r]; reg != "" {
+		return reg
+	}
+	return "rax"
+}
+
+// setReg は def で得たレジスタ reg の値を仮想レジスタ r に書き戻す
+func (g *generator) setReg(fr *irFrame, r int, reg string) {
+	if fr.ra.regs[r] == "" {
+		g.emit("mov", fr.loc(r), regOp(reg))
+	}
+}
+
+var irCompareSet = map[irOp]string{
+	irEq: "sete",
+	irNe: "setne",
+	irLt: "setl",
+	irLe: "setle",
+}
+
+var irArithOps = map[irOp]string{
+	irAdd: "add",
+	irSub: "sub",
+	irMul: "imul",
+}
+
+// moveArgs は call の引数を引数レジスタに並列に移す。
+// 移動先が他の引数の移動元になっている間は後回しにし、循環は r11 で断ち切る。
+func (g *generator) moveArgs(fr *irFrame, args []int) {
+	type move struct {
+		dst string
+		src asmOperand
+	}
+	var moves []move
+	for i, arg := range args {
+		if src := fr.loc(arg); src != regOp(argregs64[i]) {
+			moves = append(moves, move{argregs64[i], src})
+		}
+	}
+	for len(moves) > 0 {
+		progress := false
+		for i := 0; i < len(moves); i++ {
+			blocked := false
+			for j, m := range moves {
+				if j != i && m.src == regOp(moves[i].dst) {
+					blocked = true
+					break
+				}
+			}
+			if blocked {
+				continue
+			}
+			g.emit("mov", regOp(moves[i].dst), moves[i].src)
+			moves = append(moves[:i], moves[i+1:]...)
+			i--
+			progress = true
+		}
+		if !progress {
+			// 残りはすべて循環している
+			src := moves[0].src
+			g.emit("mov", regOp("r11"), src)
+			for i := range moves {
+				if moves[i].src == src {
+					moves[i].src = regOp("r11")
+				}
+			}
+		}
+	}
+}
+
+// leave は callee-saved レジスタを戻してフレームを畳む
+func (g *generator) leave(fr *irFrame) {
+	for _, reg := range fr.ra.usedCallee {
+		g.emit("mov", regOp(reg), memOp("rbp", -fr.saved[reg]))
+	}
+	g.popFrame()
+}
+
+func (g *generator) epilogue(fr *irFrame) {
+	g.comment("epilogue")
+	g.debugDirective(".cfi_remember_state")
+	g.leave(fr)
+	g.emit("ret")
+	g.debugDirective(".cfi_restore_state")
+}
+
+func (g *generator) genIRInst(f *irFunc, fr *irFrame, next *irBlock, inst *irInst) error {
+	switch inst.op {
+	case irImm:
+		g.emit("mov", fr.loc(inst.dst), immOp(inst.imm))
+	case irLocalAddr:
+		g.commentLocal(inst.v)
+		d := fr.def(inst.dst)
+		g.emit("lea", regOp(d), memOp("rbp", -inst.v.offset))
+		g.setReg(fr, inst.dst, d)
+	case irGlobalAddr:
+		d := fr.def(inst.dst)
+		g.globalAddr(d, inst.v)
+		g.setReg(fr, inst.dst, d)
+	case irLoad:
+		addr := memOp(g.use(fr, inst.args[0], "rax"), 0)
+		d := fr.def(inst.dst)
+		switch inst.size {
+		case 8:
+			g.emit("mov", regOp(d), addr)
+		case 4:
+			g.emit("movsxd", regOp(d), addr.ptr(4))
+		case 1:
+			g.emit("movsx", regOp(d), addr.ptr(1))
+		default:
+			return fmt.Errorf("internal error: invalid load size: %d", inst.size)
+		}
+		g.setReg(fr, inst.dst, d)
+	case irStore:
+		addr := memOp(g.use(fr, inst.args[0], "rax"), 0)
+		val := g.use(fr, inst.args[1], "r11")
+		switch inst.size {
+		case 8:
+			g.emit("mov", addr, regOp(val))
+		case 4:
+			g.emit("mov", addr, regOp(subregs32[val]))
+		case 1:
+			g.emit("mov", addr, regOp(subregs8[val]))
+		default:
+			return fmt.Errorf("internal error: invalid store size: %d", inst.size)
+		}
+	case irMov:
+		d := fr.def(inst.dst)
+		g.emit("mov", regOp(d), fr.loc(inst.args[0]))
+		g.setReg(fr, inst.dst, d)
+	case irAdd, irSub, irMul:
+		// 結果のレジスタはオペランドと重ならない（regalloc.go 参照）
+		d := regOp(fr.def(inst.dst))
+		g.emit("mov", d, fr.loc(inst.args[0]))
+		g.emit(irArithOps[inst.op], d, fr.loc(inst.args[1]))
+		g.setReg(fr, inst.dst, d.reg)
+	case irShl:
+		d := regOp(fr.def(inst.dst))
+		g.emit("mov", d, fr.loc(inst.args[0]))
+		g.emit("shl", d, immOp(inst.imm))
+		g.setReg(fr, inst.dst, d.reg)
+	case irLea:
+		addr := memOp(g.use(fr, inst.args[0], "rax"), 0)
+		addr.index, addr.scale = g.use(fr, inst.args[1], "r11"), 1<<inst.imm
+		d := fr.def(inst.dst)
+		g.emit("lea", regOp(d), addr)
+		g.setReg(fr, inst.dst, d)
+	case irDiv:
+		g.emit("mov", regOp("rax"), fr.loc(inst.args[0]))
+		g.emit("cqo")
+		g.emit("idiv", fr.loc(inst.args[1]))
+		g.emit("mov", fr.loc(inst.dst), regOp("rax"))
+	case irEq, irNe, irLt, irLe:
+		lhs := g.use(fr, inst.args[0], "rax")
+		g.emit("cmp", regOp(lhs), fr.loc(inst.args[1]))
+		g.emit(irCompareSet[inst.op], regOp("al"))
+		g.emit("movzx", regOp("rax"), regOp("al"))
+		g.emit("mov", fr.loc(inst.dst), regOp("rax"))
+	case irCall:
+		if len(inst.args) > len(argregs64) {
+			return fmt.Errorf("internal error: too many arguments: %d", len(inst.args))
+		}
+		// call をまたいで生きる値は callee-saved レジスタかスロットにあるので、
+		// caller-saved レジスタは退避しなくてよい
+		g.moveArgs(fr, inst.args)
+		g.emit("call", g.callTarget(inst.sym))
+		g.emit("mov", fr.loc(inst.dst), regOp("rax"))
+	case irTailCall:
+		if len(inst.args) > len(argregs64) {
+			return fmt.Errorf("internal error: too many arguments: %d", len(inst.args))
+		}
+		// 引数レジスタは leave で壊れないので、先に移してからフレームを畳む。
+		// 戻りアドレスは呼び出し元のものがそのまま残る。
+		g.moveArgs(fr, inst.args)
+		g.comment("epilogue (tail call)")
+		g.debugDirective(".cfi_remember_state")
+		g.leave(fr)
+		g.emit("jmp", g.callTarget(inst.sym))
+		g.debugDirective(".cfi_restore_state")
+	case irJmp:
+		if inst.then != next {
+			g.emit("jmp", symOp(g.irLabel(f, inst.then)))
+		}
+	case irBr:
+		g.emit("cmp", fr.loc(inst.args[0]), immOp(0))
+		g.emit("je", symOp(g.irLabel(f, inst.els)))
+		if inst.then != next {
+			g.emit("jmp", symOp(g.irLabel(f, inst.then)))
+		}
+	case irRet:
+		if len(inst.args) > 0 {
+			g.emit("mov", regOp("rax"), fr.loc(inst.args[0]))
+		}
+		g.epilogue(fr)
+	default:
+		return fmt.Errorf("internal error: unknown IR op: %s", inst.op)
+	}
+	return nil
+}
+
+func (g *generator) genIRFunc(f *irFunc) error {
+	fr := newIRFrame(f)
+	if !f.fn.isStatic {
+		g.directive(".global %s", f.name())
+	}
+	g.directive(".type %s, @function", f.name())
+	g.funcStart(f.fn)
+
+	// プロローグ
+	g.pushFrame()
+	g.emit("sub", regOp("rsp"), immOp(fr.stackSize))
+	for _, reg := range fr.ra.usedCallee {
+		g.emit("mov", memOp("rbp", -fr.saved[reg]), regOp(reg))
+		// CFA は rbp+16 なので、[rbp-N] は CFA-(16+N)
+		g.debugDirective(".cfi_offset %s, %d", regOp(reg).format(g.syntax), -(16 + fr.saved[reg]))
+	}
+	i := 0
+	for param := f.fn.params; param != nil; param = param.next {
+		switch param.ty.size {
+		case 8:
+			g.emit("mov", memOp("rbp", -param.offset), regOp(argregs64[i]))
+		case 4:
+			g.emit("mov", memOp("rbp", -param.offset), regOp(argregs32[i]))
+		case 1:
+			g.emit("mov", memOp("rbp", -param.offset), regOp(argregs8[i]))
+		}
+		i++
+	}
+
+	for i, b := range f.blocks {
+		var next *irBlock
+		if i+1 < len(f.blocks) {
+			next = f.blocks[i+1]
+		}
+		g.label("%s", g.irLabel(f, b))
+		if g.verbose != nil {
+			g.verbose.lastLine = 0 // ブロックごとにソースの行を書き直す
+		}
+		for _, inst := range b.insts {
+			g.lineLoc(inst.tok)
+			g.commentLine(inst.tok)
+			if err := g.genIRInst(f, fr, next, inst); err != nil {
+				return err
+			}
+		}
+	}
+	g.funcEnd(f.fn)
+	return nil
+}
+
+// codegenIR は IR から x86-64 アセンブリを生成する
+func codegenIR(p *irProgram, w io.Writer, c *compilation) error {
+	g := newGenerator(w, p.prog, c)
+	g.fileDirective()
+	g.emitData(p.prog)
+	g.textSection()
+	for _, f := range p.funcs {
+		if err := g.genIRFunc(f); err != nil {
+			return err
+		}
+	}
+	g.emitDebugInfo(p.prog)
+	return g.flush()
+}
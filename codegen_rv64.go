@@ -0,0 +1,348 @@
+package g9cc
+
+import (
+	"fmt"
+	"io"
+)
+
+// RISC-V（RV64GC、LP64D ABI）向けのコード生成（-target riscv64-linux）。
+// AArch64 と同じくスタックマシンとして式を計算する。a0 が計算結果、a1 が二項演算の右辺で、
+// スタックへの push/pop は sp を 16 バイトにそろえたまま 1 つの値に 16 バイトを使う。
+// フレームは s0（fp）を基準にし、ローカル変数は -offset(s0) に置く。
+
+var riscvArgRegs = []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7"}
+
+// riscvGenerator は RISC-V のコード生成の状態。データの出力と書き出しは generator を使う。
+type riscvGenerator struct {
+	*generator
+}
+
+// ins は命令を 1 行積む
+func (g *riscvGenerator) ins(format string, args ...any) {
+	g.directive("\t"+format, args...)
+}
+
+func (g *riscvGenerator) push() {
+	g.ins("addi sp, sp, -16")
+	g.ins("sd a0, 0(sp)")
+}
+
+func (g *riscvGenerator) pop(reg string) {
+	g.ins("ld %s, 0(sp)", reg)
+	g.ins("addi sp, sp, 16")
+}
+
+// frameAddr は reg に s0 - offset を求める（addi の即値は 12 ビット）
+func (g *riscvGenerator) frameAddr(reg string, offset int) {
+	if offset <= 2048 {
+		g.ins("addi %s, s0, %d", reg, -offset)
+		return
+	}
+	g.ins("li t0, %d", offset)
+	g.ins("sub %s, s0, t0", reg)
+}
+
+// globalAddr は a0 にグローバル変数 v のアドレスを求める。
+// -fPIC では static でない変数のアドレスを GOT から読む。
+func (g *riscvGenerator) globalAddr(v *obj) {
+	if g.pic && !v.isStatic && !isStringLiteral(v) {
+		cnt := g.count()
+		g.label(".Lpcrel_hi%d", cnt)
+		g.ins("auipc a0, %%got_pcrel_hi(%s)", *v.name)
+		g.ins("ld a0, %%pcrel_lo(.Lpcrel_hi%d)(a0)", cnt)
+		return
+	}
+	g.ins("lla a0, %s", *v.name)
+}
+
+// load は a0 が指す ty 型の値を a0 に読む（int と char は符号拡張する）
+func (g *riscvGenerator) load(ty *ty) {
+	switch {
+	case ty.kind == tyArray:
+	case ty.size == 8:
+		g.ins("ld a0, 0(a0)")
+	case ty.size == 4:
+		g.ins("lw a0, 0(a0)")
+	case ty.size == 1:
+		g.ins("lb a0, 0(a0)")
+	}
+}
+
+// store は a0 の値を a1 が指す ty 型の場所に書く
+func (g *riscvGenerator) store(ty *ty) {
+	switch ty.size {
+	case 8:
+		g.ins("sd a0, 0(a1)")
+	case 4:
+		g.ins("sw a0, 0(a1)")
+	case 1:
+		g.ins("sb a0, 0(a1)")
+	}
+}
+
+func (g *riscvGenerator) genExpr(node *node) error {
+	switch node.kind {
+	case ndNum:
+		g.ins("li a0, %d", node.val)
+		g.push()
+		return nil
+	case ndVar, ndDeref:
+		if node.kind == ndVar {
+			if err := g.genAddr(node); err != nil {
+				return err
+			}
+		} else if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("a0")
+		g.load(node.ty)
+		g.push()
+		return nil
+	case ndAssign:
+		if err := g.genAddr(node.lhs); err != nil {
+			return err
+		}
+		if err := g.genExpr(node.rhs); err != nil {
+			return err
+		}
+		g.pop("a0")
+		g.pop("a1")
+		g.store(node.lhs.ty)
+		g.push()
+		return nil
+	case ndFuncall:
+		// 引数の個数は sema で検査済み
+		if len(node.args) > len(riscvArgRegs) {
+			return fmt.Errorf("internal error: too many arguments: %d", len(node.args))
+		}
+		for i := len(node.args) - 1; i >= 0; i-- {
+			if err := g.genExpr(node.args[i]); err != nil {
+				return err
+			}
+		}
+		for i := range node.args {
+			g.pop(riscvArgRegs[i])
+		}
+		// LP64 では int や char の戻り値は呼ばれた側が 64 ビットに符号拡張する
+		g.ins("call %s", node.funcname)
+		g.push()
+		return nil
+	case ndAddr:
+		return g.genAddr(node.lhs)
+	}
+
+	if err := g.genExpr(node.lhs); err != nil {
+		return err
+	}
+	if err := g.genExpr(node.rhs); err != nil {
+		return err
+	}
+	g.pop("a1")
+	g.pop("a0")
+
+	switch node.kind {
+	case ndAdd:
+		g.ins("add a0, a0, a1")
+	case ndSub:
+		g.ins("sub a0, a0, a1")
+	case ndMul:
+		g.ins("mul a0, a0, a1")
+	case ndDiv:
+		g.ins("div a0, a0, a1")
+	case ndEq:
+		g.ins("xor a0, a0, a1")
+		g.ins("seqz a0, a0")
+	case ndNe:
+		g.ins("xor a0, a0, a1")
+		g.ins("snez a0, a0")
+	case ndLt:
+		g.ins("slt a0, a0, a1")
+	case ndLe:
+		// a <= b は !(b < a)
+		g.ins("slt a0, a1, a0")
+		g.ins("xori a0, a0, 1")
+	default:
+		return fmt.Errorf("internal error: unexpected node kind: %d", node.kind)
+	}
+	g.push()
+	return nil
+}
+
+// genAddr は左辺値のアドレスを push する
+func (g *riscvGenerator) genAddr(node *node) error {
+	switch node.kind {
+	case ndVar:
+		if node.lvar.isLocal {
+			g.frameAddr("a0", node.lvar.offset)
+		} else {
+			g.globalAddr(node.lvar)
+		}
+		g.push()
+		return nil
+	case ndDeref:
+		return g.genExpr(node.lhs)
+	}
+
+	// 左辺値の検査は sema で済んでいる
+	return fmt.Errorf("internal error: not an lvalue: %d", node.kind)
+}
+
+func (g *riscvGenerator) genStmt(node *node) error {
+	switch node.kind {
+	case ndExprStmt:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("a0")
+		return nil
+	case ndReturn:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("a0")
+		g.epilogue()
+		return nil
+	case ndIf:
+		cnt := g.count()
+		if err := g.genExpr(node.cond); err != nil {
+			return err
+		}
+		g.pop("a0")
+		g.ins("beqz a0, .Lelse%d", cnt)
+		if err := g.genStmt(node.then); err != nil {
+			return err
+		}
+		g.ins("j .Lend%d", cnt)
+		g.label(".Lelse%d", cnt)
+		if node.els != nil {
+			if err := g.genStmt(node.els); err != nil {
+				return err
+			}
+		}
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndWhile:
+		cnt := g.count()
+		g.label(".Lbegin%d", cnt)
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.pop("a0")
+		g.ins("beqz a0, .Lend%d", cnt)
+		if err := g.genStmt(node.rhs); err != nil {
+			return err
+		}
+		g.ins("j .Lbegin%d", cnt)
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndFor:
+		cnt := g.count()
+		if node.init != nil {
+			if err := g.genExpr(node.init); err != nil {
+				return err
+			}
+			g.pop("a0")
+		}
+		g.label(".Lbegin%d", cnt)
+		if node.cond != nil {
+			if err := g.genExpr(node.cond); err != nil {
+				return err
+			}
+			g.pop("a0")
+			g.ins("beqz a0, .Lend%d", cnt)
+		}
+		if node.then != nil {
+			if err := g.genStmt(node.then); err != nil {
+				return err
+			}
+		}
+		if node.inc != nil {
+			if err := g.genExpr(node.inc); err != nil {
+				return err
+			}
+			g.pop("a0")
+		}
+		g.ins("j .Lbegin%d", cnt)
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndBlock:
+		for n := node.lhs; n != nil; n = n.next {
+			if err := g.genStmt(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("internal error: invalid statement: %d", node.kind)
+	}
+}
+
+// epilogue はフレームを畳んで戻る（戻り値は a0）
+func (g *riscvGenerator) epilogue() {
+	g.ins("mv sp, s0")
+	g.ins("ld ra, 8(sp)")
+	g.ins("ld s0, 0(sp)")
+	g.ins("addi sp, sp, 16")
+	g.ins("ret")
+}
+
+func (g *riscvGenerator) genFunc(fn *obj) error {
+	if !fn.isStatic {
+		g.directive(".global %s", *fn.name)
+	}
+	g.directive(".type %s, @function", *fn.name)
+	g.label("%s", *fn.name)
+
+	// プロローグ。ra（戻りアドレス）と s0（フレームポインタ）を保存する
+	g.ins("addi sp, sp, -16")
+	g.ins("sd ra, 8(sp)")
+	g.ins("sd s0, 0(sp)")
+	g.ins("mv s0, sp")
+	if size := frameSize(fn); size > 0 {
+		if size <= 2048 {
+			g.ins("addi sp, sp, %d", -size)
+		} else {
+			g.ins("li t0, %d", size)
+			g.ins("sub sp, sp, t0")
+		}
+	}
+	i := 0
+	for param := fn.params; param != nil; param = param.next {
+		g.frameAddr("t1", param.offset)
+		switch param.ty.size {
+		case 8:
+			g.ins("sd %s, 0(t1)", riscvArgRegs[i])
+		case 4:
+			g.ins("sw %s, 0(t1)", riscvArgRegs[i])
+		case 1:
+			g.ins("sb %s, 0(t1)", riscvArgRegs[i])
+		}
+		i++
+	}
+
+	if err := g.genStmt(fn.body); err != nil {
+		return err
+	}
+	if fn.returnsZero {
+		g.ins("li a0, 0")
+	}
+	g.epilogue()
+	g.directive(".size %s, .-%s", *fn.name, *fn.name)
+	return nil
+}
+
+// codegenRISCV は型付き AST から RISC-V のアセンブリを生成する
+func codegenRISCV(prog *obj, w io.Writer, c *compilation) error {
+	g := &riscvGenerator{generator: newGenerator(w, prog, c)}
+	g.emitData(prog)
+	g.directive(".text")
+	for v := prog; v != nil; v = v.next {
+		if !v.isFunction {
+			continue
+		}
+		if err := g.genFunc(v); err != nil {
+			return err
+		}
+	}
+	return g.flush()
+}
@@ -0,0 +1,296 @@
+// Package g9cc は小さな C コンパイラ g9cc をライブラリとして提供する。
+//
+// Compile はソースから x86-64（Options.Target によっては AArch64 か RISC-V）のアセンブリ、
+// または WebAssembly のモジュールを生成する。Tokenize と Parse は途中段階（トークン列・型付き AST）を取り出すために使う。
+package g9cc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Options はコンパイルの設定
+type Options struct {
+	MaxErrors int // この数のエラーで解析を打ち切る（0 なら無制限）
+
+	// Warnings は警告カテゴリごとの有効・無効。指定のないカテゴリは既定値に従う。
+	Warnings map[string]bool
+	// WarningsAsErrors は警告をエラーとして扱う（-Werror）
+	WarningsAsErrors bool
+
+	// EmitIR はアセンブリの代わりに IR のテキストを出力する（-emit-ir）
+	EmitIR bool
+	// EmitLLVM はアセンブリの代わりにテキスト形式の LLVM IR を出力する（-emit-llvm）。
+	// ターゲットトリプルは Target から決める。
+	EmitLLVM bool
+	// EmitC はアセンブリの代わりに型付き AST から書き戻した C を出力する（-emit-c）
+	EmitC bool
+	// Desugar は EmitC で、パーサと sema が書き換えた形（*(x+y) など）をそのまま書く（-emit-c=desugared）
+	Desugar bool
+	// ASTDump はアセンブリの代わりに AST を出力する（-ast-dump）。"text"（字下げした木）、
+	// "json"、"dot"（Graphviz）のいずれか。空文字列なら出力しない。
+	ASTDump string
+	// ASTDumpBeforeSema は ASTDump で sema の前（型付けや書き換えの前）の AST を出力する（-ast-dump-before-sema）
+	ASTDumpBeforeSema bool
+	// IRCodegen は AST から直接ではなく IR を経由してアセンブリを生成する（-fir-codegen）
+	IRCodegen bool
+	// OptLevel は最適化レベル（-O0, -O1, -O2）。1 以上なら IR を経由し、最適化パスを走らせる。
+	OptLevel int
+	// DumpPasses が nil でなければ、最適化の各パスの後の IR をそこに書く（-print-after-all）
+	DumpPasses io.Writer
+	// Peephole は書き出す直前のアセンブリに peephole 最適化をかける（-fpeephole）
+	Peephole bool
+	// PeepholeStats が nil でなければ、peephole の規則ごとに消した命令の数をそこに書く（-fpeephole-stats）
+	PeepholeStats io.Writer
+	// PIC は位置独立コードを出す（-fPIC）。static でない変数は GOT、関数は PLT を通して参照する。
+	PIC bool
+	// Debug は行番号・呼び出しフレーム・変数の DWARF デバッグ情報を出す（-g）
+	Debug bool
+	// ATTSyntax はアセンブリを Intel 記法ではなく AT&T 記法で出力する（-masm=att）
+	ATTSyntax bool
+	// VerboseAsm は文ごとのソースやローカル変数の名前をコメントとしてアセンブリに書く（-fverbose-asm）
+	VerboseAsm bool
+	// Target は出力するアセンブリのターゲット（-target）。"x86_64-linux"（空文字列も同じ）、
+	// "aarch64-linux"、"riscv64-linux"、"wasm32-wasi"（WAT を出力する）のいずれか。
+	// x86-64 以外では最適化・IR からのコード生成・デバッグ情報などは使えない。
+	Target string
+}
+
+// check はオプションの組み合わせを調べる
+func (o *Options) check() error {
+	b, err := o.backend()
+	if err != nil {
+		return err
+	}
+	var outputs []string
+	for _, out := range []struct {
+		on   bool
+		name string
+	}{
+		{o.EmitIR, "-emit-ir"},
+		{o.EmitLLVM, "-emit-llvm"},
+		{o.EmitC, "-emit-c"},
+		{o.ASTDump != "", "-ast-dump"},
+	} {
+		if out.on {
+			outputs = append(outputs, out.name)
+		}
+	}
+	if len(outputs) > 1 {
+		return fmt.Errorf("%s cannot be used together", strings.Join(outputs, " and "))
+	}
+	switch o.ASTDump {
+	case "", "text", "json", "dot":
+	default:
+		return fmt.Errorf("unknown AST dump format: %s", o.ASTDump)
+	}
+	if _, ok := b.(x86Backend); ok || o.EmitLLVM || o.EmitC || o.ASTDump != "" {
+		return nil
+	}
+	unsupported := []struct {
+		on   bool
+		name string
+	}{
+		{o.OptLevel > 0, fmt.Sprintf("-O%d", o.OptLevel)},
+		{o.IRCodegen, "-fir-codegen"},
+		{o.DumpPasses != nil, "-print-after-all"},
+		{o.Peephole, "-fpeephole"},
+		{o.Debug, "-g"},
+		{o.VerboseAsm, "-fverbose-asm"},
+		{o.ATTSyntax, "-masm=att"},
+		{o.PIC && o.Target == "wasm32-wasi", "-fPIC"},
+	}
+	for _, u := range unsupported {
+		if u.on {
+			return fmt.Errorf("%s is not supported for target %s", u.name, o.Target)
+		}
+	}
+	return nil
+}
+
+// backend は Target のコード生成を返す
+func (o *Options) backend() (backend, error) {
+	if o.Target == "" {
+		return x86Backend{}, nil
+	}
+	b, ok := targets[o.Target]
+	if !ok {
+		return nil, fmt.Errorf("unknown target: %s", o.Target)
+	}
+	return b, nil
+}
+
+// ErrCompile は入力に誤りがありコンパイルできなかったことを表す。
+// 詳細は一緒に返される []Diagnostic に入っている。
+var ErrCompile = errors.New("compilation failed")
+
+// compilation は1回のコンパイルの入力と診断を保持する
+type compilation struct {
+	filename string
+	input    string
+	opts     Options
+	diags    diagSink
+	maxArgs  int // ターゲットで渡せる引数の数
+}
+
+func newCompilation(filename string, src []byte, opts Options) *compilation {
+	c := &compilation{filename: filename, input: string(src), opts: opts}
+	c.diags.maxErrors = opts.MaxErrors
+	c.diags.warnings = opts.enabledWarnings()
+	c.diags.werror = opts.WarningsAsErrors
+	c.maxArgs = len(argregs64)
+	if b, err := opts.backend(); err == nil {
+		c.maxArgs = b.maxArgs()
+	}
+	return c
+}
+
+// diagnostics は集めた診断にファイル名を付け、ソース上の順に並べて返す
+func (c *compilation) diagnostics() []Diagnostic {
+	var out []Diagnostic
+	for _, d := range c.diags.diags {
+		d.Pos.Filename = c.filename
+		for i := range d.Notes {
+			d.Notes[i].Pos.Filename = c.filename
+		}
+		out = append(out, *d)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Pos.Offset < out[j].Pos.Offset })
+	return out
+}
+
+// check は段階ごとの結果をまとめる。記録済みのエラーがあれば ErrCompile を、
+// 内部エラーならそれをそのまま返す。
+func (c *compilation) check(err error) error {
+	if err != nil && !errors.Is(err, errTooManyErrors) {
+		if err := c.diags.report(err); err == nil || errors.Is(err, errTooManyErrors) {
+			return ErrCompile
+		}
+		return err
+	}
+	if c.diags.nerrors > 0 {
+		return ErrCompile
+	}
+	return nil
+}
+
+func (c *compilation) tokenize() (*token, error) {
+	tok, err := tokenize(c.input)
+	if err := c.check(err); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// frontend は tokenize -> parse -> sema を行い型付き AST を返す。
+// 構文エラーがあっても回復できた部分は型付けまで行い、エラーをまとめて報告する。
+func (c *compilation) frontend() (*obj, error) {
+	prog, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.check(sema(prog, c.input, &c.diags, c.maxArgs)); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// parse は tokenize -> parse を行い、型付け前の AST を返す。
+// 回復した構文エラーは記録したまま AST を返す（呼び出し側が check で調べる）。
+func (c *compilation) parse() (*obj, error) {
+	tok, err := c.tokenize()
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tok: tok, locals: nil, nextOffset: 0, input: c.input, diags: &c.diags, maxArgs: c.maxArgs}
+	prog, err := p.parse()
+	if errors.Is(err, errTooManyErrors) {
+		return nil, ErrCompile
+	}
+	if err != nil {
+		return nil, c.check(err)
+	}
+	return prog, nil
+}
+
+// backend は型付き AST から出力を生成する。-emit-ir なら IR を、-emit-llvm なら LLVM IR を、
+// -emit-c なら C を、-ast-dump なら AST を、そうでなければターゲットのコード生成（backend）でアセンブリを書く。
+func (c *compilation) backend(prog *obj, w io.Writer) error {
+	if c.opts.ASTDump != "" {
+		return dumpAST(c.exportProgram(prog), c.opts.ASTDump, w)
+	}
+	if c.opts.EmitLLVM {
+		return codegenLLVM(prog, w, c)
+	}
+	if c.opts.EmitC {
+		return printC(prog, w, c.opts.Desugar)
+	}
+	foldProgram(prog)
+	if c.opts.EmitIR {
+		ir, err := lowerAndOptimize(prog, c)
+		if err != nil {
+			return err
+		}
+		return ir.dump(w)
+	}
+	b, err := c.opts.backend()
+	if err != nil {
+		return err
+	}
+	return b.generate(prog, w, c)
+}
+
+// Compile は src を Options.Target のアセンブリに変換する。x86-64 では既定は Intel 記法、
+// Options.ATTSyntax なら AT&T 記法で出力する。
+// 入力に誤りがある場合は ErrCompile と診断を返す。
+func Compile(filename string, src []byte, opts Options) ([]byte, []Diagnostic, error) {
+	if err := opts.check(); err != nil {
+		return nil, nil, err
+	}
+	c := newCompilation(filename, src, opts)
+	var prog *obj
+	var err error
+	if opts.ASTDump != "" && opts.ASTDumpBeforeSema {
+		// sema の前で止める
+		prog, err = c.parse()
+		if err == nil {
+			err = c.check(nil)
+		}
+	} else {
+		prog, err = c.frontend()
+	}
+	if err != nil {
+		return nil, c.diagnostics(), err
+	}
+
+	var buf bytes.Buffer
+	if err := c.backend(prog, &buf); err != nil {
+		return nil, c.diagnostics(), err
+	}
+	return buf.Bytes(), c.diagnostics(), nil
+}
+
+// Tokenize は src をトークン列に変換する。末尾は Kind が "eof" のトークン。
+func Tokenize(filename string, src []byte) ([]Token, []Diagnostic, error) {
+	c := newCompilation(filename, src, Options{})
+	tok, err := c.tokenize()
+	if err != nil {
+		return nil, c.diagnostics(), err
+	}
+	return c.exportTokens(tok), c.diagnostics(), nil
+}
+
+// Parse は src を解析し、型付けまで済んだ AST を返す
+func Parse(filename string, src []byte, opts Options) (*Program, []Diagnostic, error) {
+	c := newCompilation(filename, src, opts)
+	prog, err := c.frontend()
+	if err != nil {
+		return nil, c.diagnostics(), err
+	}
+	return c.exportProgram(prog), c.diagnostics(), nil
+}
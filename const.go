@@ -0,0 +1,116 @@
+package g9cc
+
+// 整数定数式の評価と、定数の部分木の畳み込み。
+// 値は int（32ビット）として丸める。sizeof は addType で ndNum になっている。
+
+// evalBinary は二項演算を計算する。0 除算のときは ok が false。
+func evalBinary(kind nodeKind, lhs, rhs int) (val int, ok bool) {
+	switch kind {
+	case ndAdd:
+		val = lhs + rhs
+	case ndSub:
+		val = lhs - rhs
+	case ndMul:
+		val = lhs * rhs
+	case ndDiv:
+		if rhs == 0 {
+			return 0, false
+		}
+		val = lhs / rhs
+	case ndEq:
+		val = boolToInt(lhs == rhs)
+	case ndNe:
+		val = boolToInt(lhs != rhs)
+	case ndLt:
+		val = boolToInt(lhs < rhs)
+	case ndLe:
+		val = boolToInt(lhs <= rhs)
+	default:
+		return 0, false
+	}
+	return int(int32(val)), true
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isBinaryArith(kind nodeKind) bool {
+	switch kind {
+	case ndAdd, ndSub, ndMul, ndDiv, ndEq, ndNe, ndLt, ndLe:
+		return true
+	}
+	return false
+}
+
+// eval は型付け済みの整数定数式の値を返す。定数でなければエラー。
+func (c *checker) eval(n *node) (int, error) {
+	if n.kind == ndNum {
+		return n.val, nil
+	}
+	if !isBinaryArith(n.kind) || !isIntegerType(n.ty) || !isIntegerType(n.lhs.ty) || !isIntegerType(n.rhs.ty) {
+		return 0, c.errorAt(n, "expression is not an integer constant")
+	}
+	lhs, err := c.eval(n.lhs)
+	if err != nil {
+		return 0, err
+	}
+	rhs, err := c.eval(n.rhs)
+	if err != nil {
+		return 0, err
+	}
+	val, ok := evalBinary(n.kind, lhs, rhs)
+	if !ok {
+		return 0, c.errorAt(n, "division by zero in constant expression")
+	}
+	return val, nil
+}
+
+// constValue は型付け済みの整数定数式の値を返す。定数でなければ（0 除算も）ok が false。
+func constValue(n *node) (int, bool) {
+	if n.kind == ndNum {
+		return n.val, true
+	}
+	if !isBinaryArith(n.kind) || !isIntegerType(n.ty) {
+		return 0, false
+	}
+	lhs, ok := constValue(n.lhs)
+	if !ok {
+		return 0, false
+	}
+	rhs, ok := constValue(n.rhs)
+	if !ok {
+		return 0, false
+	}
+	return evalBinary(n.kind, lhs, rhs)
+}
+
+// foldProgram は各関数の本体の定数を畳み込む。sema の後の AST を書く出力
+// （-ast-dump、-emit-c、-emit-llvm）には使わず、IR への変換とコード生成の直前に行う。
+func foldProgram(prog *obj) {
+	for fn := prog; fn != nil; fn = fn.next {
+		if fn.isFunction {
+			foldConst(fn.body)
+		}
+	}
+}
+
+// foldConst は整数型の定数の部分木を ndNum に置き換える。
+// 0 除算は実行時の動作を残すため畳み込まない。
+func foldConst(n *node) {
+	forEachChild(n, foldConst)
+	if !isBinaryArith(n.kind) || !isIntegerType(n.ty) || n.lhs.kind != ndNum || n.rhs.kind != ndNum {
+		return
+	}
+	val, ok := evalBinary(n.kind, n.lhs.val, n.rhs.val)
+	if !ok {
+		return
+	}
+	n.kind = ndNum
+	n.val = val
+	n.lhs = nil
+	n.rhs = nil
+}
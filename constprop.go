@@ -0,0 +1,91 @@
+package g9cc
+
+// constProp は定数同士の演算を畳み込み、恒等式（x+0, x*1 など）を簡約し、
+// 条件が定数の分岐を jmp にする。到達しなくなったブロックは取り除く。
+func constProp(f *irFunc) {
+	defs := f.defs()
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.blocks {
+			for _, inst := range b.insts {
+				if inst.dst != 0 && defs[inst.dst] != inst {
+					continue // 簡約で使われなくなった命令（dce で消す）
+				}
+				if foldInst(f, defs, inst) {
+					changed = true
+				}
+			}
+		}
+	}
+	f.removeUnreachable()
+}
+
+// foldInst は inst を 1 回簡約し、変化があれば true を返す
+func foldInst(f *irFunc, defs []*irInst, inst *irInst) bool {
+	switch inst.op {
+	case irBr:
+		c, ok := constOf(defs, inst.args[0])
+		if !ok {
+			return false
+		}
+		target := inst.then
+		if c == 0 {
+			target = inst.els
+		}
+		*inst = irInst{op: irJmp, then: target, tok: inst.tok}
+		return true
+	case irShl:
+		if l, ok := constOf(defs, inst.args[0]); ok {
+			toImm(inst, int(int32(l<<inst.imm)))
+			return true
+		}
+		return false
+	}
+
+	kind, ok := irNodeKind(inst.op)
+	if !ok {
+		return false
+	}
+	l, lok := constOf(defs, inst.args[0])
+	r, rok := constOf(defs, inst.args[1])
+	if lok && rok {
+		v, ok := evalBinary(kind, l, r)
+		if !ok {
+			return false // 0 除算は実行時に任せる
+		}
+		toImm(inst, v)
+		return true
+	}
+
+	// 片方だけ定数の恒等式
+	x := 0
+	switch {
+	case inst.op == irAdd && rok && r == 0, inst.op == irSub && rok && r == 0,
+		inst.op == irMul && rok && r == 1, inst.op == irDiv && rok && r == 1:
+		x = inst.args[0]
+	case inst.op == irAdd && lok && l == 0, inst.op == irMul && lok && l == 1:
+		x = inst.args[1]
+	case inst.op == irMul && (rok && r == 0 || lok && l == 0):
+		toImm(inst, 0)
+		return true
+	default:
+		return false
+	}
+	f.replaceUses(inst.dst, x)
+	defs[inst.dst] = nil
+	return true
+}
+
+func toImm(inst *irInst, v int) {
+	*inst = irInst{op: irImm, dst: inst.dst, imm: v, tok: inst.tok}
+}
+
+// irNodeKind は二項演算の命令に対応する AST の演算子を返す
+func irNodeKind(op irOp) (nodeKind, bool) {
+	for kind, o := range irBinaryOps {
+		if o == op {
+			return kind, true
+		}
+	}
+	return 0, false
+}
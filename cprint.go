@@ -0,0 +1,395 @@
+package g9cc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// -emit-c: 型付き AST からコンパイルできる C を書き戻す。
+//
+// 既定ではパーサと sema が書き換えた形をソースの書き方に戻す。
+//
+//   - *(x + y) で tok が "[" のもの -> x[y]
+//   - ndLt(b, a) で tok が ">" のもの -> a > b（">=" も同じ）
+//   - 0 - x で 0 が "-" のトークンのもの -> -x
+//   - p + i*size -> p + i
+//   - (p - q) / size（ポインタの差） -> p - q
+//
+// sizeof は sema で値に置き換わっているので数を書く。
+// Desugar ならこれらを戻さず AST のとおりに書く。ポインタの演算を含むと、
+// その出力をもう一度コンパイルしても同じプログラムにはならない。
+
+// 式の優先順位（大きいほど強く結合する）
+const (
+	precAssign = iota + 1
+	precEquality
+	precRelational
+	precAdd
+	precMul
+	precUnary
+	precPostfix
+	precPrimary
+)
+
+type cPrinter struct {
+	w       *bufio.Writer
+	desugar bool
+	indent  int
+
+	decls map[*node][]*obj // 宣言の文ごとの、そこで宣言されたローカル変数
+}
+
+func printC(prog *obj, w io.Writer, desugar bool) error {
+	p := &cPrinter{w: bufio.NewWriter(w), desugar: desugar}
+
+	// 関数とグローバル変数をソースの順に並べる（文字列リテラルは式の中に書く）
+	var tops []*obj
+	for v := prog; v != nil; v = v.next {
+		if !v.isFunction && isStringLiteral(v) {
+			continue
+		}
+		tops = append(tops, v)
+	}
+	sort.SliceStable(tops, func(i, j int) bool { return tops[i].tok.pos < tops[j].tok.pos })
+
+	for i, v := range tops {
+		if v.isFunction {
+			if i > 0 {
+				p.line("")
+			}
+			p.function(v)
+		} else {
+			p.global(v)
+		}
+	}
+	return p.w.Flush()
+}
+
+func (p *cPrinter) line(format string, args ...any) {
+	if format != "" {
+		p.w.WriteString(strings.Repeat("  ", p.indent))
+		fmt.Fprintf(p.w, format, args...)
+	}
+	p.w.WriteByte('\n')
+}
+
+// storageSpec は static・extern・inline を宣言の先頭に書く形にする
+func storageSpec(v *obj) string {
+	s := ""
+	if v.isStatic {
+		s += "static "
+	}
+	if v.isExtern {
+		s += "extern "
+	}
+	if v.isInline {
+		s += "inline "
+	}
+	return s
+}
+
+// declspecString は基本型を declspec の形（const int など）にする
+func declspecString(t *ty) string {
+	name := "int"
+	if t.kind == tyChar {
+		name = "char"
+	}
+	if t.isConst {
+		return "const " + name
+	}
+	return name
+}
+
+// declarator は変数 v の型から、宣言子（*p, a[3] など）と基本型を返す
+func declarator(v *obj) (string, *ty) {
+	t := v.ty
+	dims := ""
+	for t.kind == tyArray {
+		dims += fmt.Sprintf("[%d]", t.arrayLen)
+		t = t.base
+	}
+	stars := ""
+	for t.kind == tyPtr {
+		stars += "*"
+		t = t.base
+	}
+	return stars + *v.name + dims, t
+}
+
+func (p *cPrinter) global(v *obj) {
+	decl, base := declarator(v)
+	if v.initData != nil {
+		// 初期化子は整数定数なので、リトルエンディアンのバイト列から値に戻す
+		val := 0
+		data := *v.initData
+		for i := len(data) - 1; i >= 0; i-- {
+			val = val<<8 | int(data[i])
+		}
+		switch len(data) {
+		case 1:
+			val = int(int8(val))
+		case 4:
+			val = int(int32(val))
+		}
+		p.line("%s%s %s = %d;", storageSpec(v), declspecString(base), decl, val)
+		return
+	}
+	p.line("%s%s %s;", storageSpec(v), declspecString(base), decl)
+}
+
+func (p *cPrinter) function(fn *obj) {
+	var params []string
+	for v := fn.params; v != nil; v = v.next {
+		params = append(params, fmt.Sprintf("%s %s", v.ty, *v.name))
+	}
+	p.decls = declaredLocals(fn)
+	fmt.Fprintf(p.w, "%s%s %s(%s) ", storageSpec(fn), fn.ty.returnTy, *fn.name, strings.Join(params, ", "))
+	p.block(fn.body)
+	p.w.WriteByte('\n')
+}
+
+// isDeclaration は宣言の文（declaration が作ったブロック）かどうかを返す
+func isDeclaration(n *node) bool {
+	if n.kind != ndBlock {
+		return false
+	}
+	switch n.tok.kind {
+	case tkInt, tkChar, tkConst:
+		return true
+	}
+	return false
+}
+
+// declaredLocals はローカル変数を宣言した文に割り振る。AST には宣言の文に初期化子しか
+// 残らないので、変数の名前の位置の直前から始まる宣言の文を探す。
+func declaredLocals(fn *obj) map[*node][]*obj {
+	var stmts []*node
+	walkNodes(fn.body, func(n *node) {
+		if isDeclaration(n) {
+			stmts = append(stmts, n)
+		}
+	})
+	sort.Slice(stmts, func(i, j int) bool { return stmts[i].tok.pos < stmts[j].tok.pos })
+
+	decls := map[*node][]*obj{}
+	_, locals := frameVars(fn)
+	for _, v := range locals {
+		i := sort.Search(len(stmts), func(i int) bool { return stmts[i].tok.pos > v.tok.pos }) - 1
+		if i < 0 {
+			continue
+		}
+		decls[stmts[i]] = append(decls[stmts[i]], v)
+	}
+	return decls
+}
+
+// block は { } のブロックを書く。最初の "{" は呼び出し側の行に続ける。
+func (p *cPrinter) block(n *node) {
+	p.w.WriteString("{\n")
+	p.indent++
+	for s := n.lhs; s != nil; s = s.next {
+		p.stmt(s)
+	}
+	p.indent--
+	p.w.WriteString(strings.Repeat("  ", p.indent) + "}")
+}
+
+// body は if・while・for の本体を書く。ブロックなら同じ行に続け、それ以外は字下げして次の行に書く。
+func (p *cPrinter) body(head string, n *node) {
+	if n.kind == ndBlock && n.tok.str == "{" {
+		p.w.WriteString(strings.Repeat("  ", p.indent) + head + " ")
+		p.block(n)
+		p.w.WriteByte('\n')
+		return
+	}
+	p.line("%s", head)
+	p.indent++
+	p.stmt(n)
+	p.indent--
+}
+
+func (p *cPrinter) stmt(n *node) {
+	switch n.kind {
+	case ndBlock:
+		switch {
+		case n.tok.str == "{":
+			p.w.WriteString(strings.Repeat("  ", p.indent))
+			p.block(n)
+			p.w.WriteByte('\n')
+		case isDeclaration(n):
+			p.declaration(n)
+		default:
+			p.line(";")
+		}
+	case ndExprStmt:
+		p.line("%s;", p.expr(n.lhs, precAssign))
+	case ndReturn:
+		p.line("return %s;", p.expr(n.lhs, precAssign))
+	case ndIf:
+		p.ifStmt("if", n)
+	case ndWhile:
+		p.body(fmt.Sprintf("while (%s)", p.expr(n.lhs, precAssign)), n.rhs)
+	case ndFor:
+		var clauses [3]string
+		for i, c := range []*node{n.init, n.cond, n.inc} {
+			if c != nil {
+				clauses[i] = p.expr(c, precAssign)
+			}
+		}
+		p.body(fmt.Sprintf("for (%s; %s; %s)", clauses[0], clauses[1], clauses[2]), n.then)
+	default:
+		p.line("%s;", p.expr(n, precAssign))
+	}
+}
+
+// ifStmt は if 文を書く。else の後の if は "else if" として続ける。
+func (p *cPrinter) ifStmt(head string, n *node) {
+	p.body(fmt.Sprintf("%s (%s)", head, p.expr(n.cond, precAssign)), n.then)
+	switch {
+	case n.els == nil:
+	case n.els.kind == ndIf:
+		p.ifStmt("else if", n.els)
+	default:
+		p.body("else", n.els)
+	}
+}
+
+// declaration は宣言の文を書く。初期化子は文の中の代入から取り出す。
+func (p *cPrinter) declaration(n *node) {
+	inits := map[*obj]*node{}
+	for s := n.lhs; s != nil; s = s.next {
+		inits[s.lhs.lhs.lvar] = s.lhs.rhs
+	}
+	vars := p.decls[n]
+	if len(vars) == 0 {
+		p.line("%s;", n.tok.str)
+		return
+	}
+	var decls []string
+	var base *ty
+	for _, v := range vars {
+		var decl string
+		decl, base = declarator(v)
+		if init, ok := inits[v]; ok {
+			decl += " = " + p.expr(init, precAssign)
+		}
+		decls = append(decls, decl)
+	}
+	p.line("%s %s;", declspecString(base), strings.Join(decls, ", "))
+}
+
+// expr は式を書く。prec より弱く結合する式と、ソースで括弧に囲まれていた式は括弧で囲む。
+func (p *cPrinter) expr(n *node, prec int) string {
+	s, own := p.exprPrec(n)
+	if own < prec || n.paren {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// binary は左結合の二項演算を書く
+func (p *cPrinter) binary(lhs *node, op string, rhs *node, prec int) (string, int) {
+	return p.expr(lhs, prec) + " " + op + " " + p.expr(rhs, prec+1), prec
+}
+
+// exprPrec は式とその優先順位を返す
+func (p *cPrinter) exprPrec(n *node) (string, int) {
+	switch n.kind {
+	case ndAssign:
+		return p.expr(n.lhs, precUnary) + " = " + p.expr(n.rhs, precAssign), precAssign
+	case ndEq:
+		return p.binary(n.lhs, "==", n.rhs, precEquality)
+	case ndNe:
+		return p.binary(n.lhs, "!=", n.rhs, precEquality)
+	case ndLt, ndLe:
+		op := "<"
+		if n.kind == ndLe {
+			op = "<="
+		}
+		if !p.desugar && (n.tok.str == ">" || n.tok.str == ">=") {
+			return p.binary(n.rhs, n.tok.str, n.lhs, precRelational)
+		}
+		return p.binary(n.lhs, op, n.rhs, precRelational)
+	case ndAdd, ndSub:
+		op := "+"
+		if n.kind == ndSub {
+			op = "-"
+		}
+		if p.desugar {
+			return p.binary(n.lhs, op, n.rhs, precAdd)
+		}
+		if n.kind == ndSub && n.lhs.kind == ndNum && n.lhs.val == 0 && n.lhs.tok == n.tok {
+			return p.unary("-", n.rhs), precUnary
+		}
+		if n.ty != nil && n.ty.kind == tyPtr && isIntegerType(n.rhs.ty) {
+			return p.expr(n.lhs, precAdd) + " " + op + " " + p.index(n), precAdd
+		}
+		return p.binary(n.lhs, op, n.rhs, precAdd)
+	case ndMul:
+		return p.binary(n.lhs, "*", n.rhs, precMul)
+	case ndDiv:
+		if !p.desugar && n.tok.str == "-" {
+			// sema がポインタの差を (p - q) / size にしたもの
+			return p.binary(n.lhs.lhs, "-", n.lhs.rhs, precAdd)
+		}
+		return p.binary(n.lhs, "/", n.rhs, precMul)
+	case ndDeref:
+		if !p.desugar && n.tok.str == "[" && n.lhs.kind == ndAdd && n.lhs.tok == n.tok && !n.lhs.paren {
+			return p.expr(n.lhs.lhs, precPostfix) + "[" + p.unscaled(n.lhs) + "]", precPostfix
+		}
+		return p.unary("*", n.lhs), precUnary
+	case ndAddr:
+		return p.unary("&", n.lhs), precUnary
+	case ndFuncall:
+		var args []string
+		for _, arg := range n.args {
+			args = append(args, p.expr(arg, precAssign))
+		}
+		return n.funcname + "(" + strings.Join(args, ", ") + ")", precPostfix
+	case ndVar:
+		if !n.lvar.isLocal && isStringLiteral(n.lvar) {
+			return `"` + strings.TrimSuffix(*n.lvar.initData, "\x00") + `"`, precPrimary
+		}
+		return *n.lvar.name, precPrimary
+	case ndNum:
+		if n.val < 0 {
+			return fmt.Sprint(n.val), precUnary
+		}
+		return fmt.Sprint(n.val), precPrimary
+	}
+	return fmt.Sprintf("/* %s */", n.kind), precPrimary
+}
+
+// unary は前置の単項演算を書く。"-" が続くときは "--" にならないよう空白を入れる。
+func (p *cPrinter) unary(op string, operand *node) string {
+	s := p.expr(operand, precUnary)
+	if op == "-" && strings.HasPrefix(s, "-") {
+		return op + " " + s
+	}
+	return op + s
+}
+
+// index はポインタと整数の加減算の整数の側を、要素の大きさを掛ける前の形で書く
+func (p *cPrinter) index(n *node) string {
+	return p.expr(p.unscale(n), precAdd+1)
+}
+
+// unscaled は x[y] の y を書く
+func (p *cPrinter) unscaled(n *node) string {
+	return p.expr(p.unscale(n), precAssign)
+}
+
+// unscale は sema が p + i*size にしたポインタの演算 n から i を取り出す
+func (p *cPrinter) unscale(n *node) *node {
+	idx := n.rhs
+	if n.ty == nil || n.ty.kind != tyPtr {
+		return idx
+	}
+	if idx.kind == ndMul && idx.tok == n.tok && idx.rhs.kind == ndNum && idx.rhs.val == n.ty.base.size {
+		return idx.lhs
+	}
+	return idx
+}
@@ -0,0 +1,127 @@
+package g9cc
+
+import "fmt"
+
+// cse は共通部分式を取り除く。
+// 副作用のない演算は支配木をたどって、支配するブロックに同じ計算があれば再利用する。
+// load は直前の store や load から値を引き継ぐ（ポインタ経由で書き換えられない
+// ローカル変数は store/call をまたいでも引き継ぐ）。引き継ぎはブロック内と、
+// 先行ブロックが 1 つだけのブロックへの入口で行う。
+func cse(f *irFunc) {
+	defs := f.defs()
+	escaped := f.escapedLocals()
+	children := f.domTree()
+	preds := f.preds()
+	repl := map[int]int{}
+	dead := map[*irInst]bool{}
+
+	replace := func(inst *irInst, to int) {
+		repl[inst.dst] = to
+		dead[inst] = true
+	}
+
+	var walk func(b *irBlock, outer map[string]int, outerMem map[memKey]int)
+	walk = func(b *irBlock, outer map[string]int, outerMem map[memKey]int) {
+		avail := map[string]int{}
+		for k, v := range outer {
+			avail[k] = v
+		}
+		mem := map[memKey]int{}
+		for k, v := range outerMem {
+			mem[k] = v
+		}
+		for _, inst := range b.insts {
+			for i, a := range inst.args {
+				if to, ok := repl[a]; ok {
+					inst.args[i] = to
+				}
+			}
+
+			switch inst.op {
+			case irLoad:
+				key := memKeyOf(defs, escaped, inst.args[0], inst.size)
+				if v, ok := mem[key]; ok {
+					replace(inst, v)
+				} else {
+					mem[key] = inst.dst
+				}
+				continue
+			case irStore:
+				key := memKeyOf(defs, escaped, inst.args[0], inst.size)
+				for k := range mem {
+					if k.v == nil || k.v == key.v {
+						delete(mem, k)
+					}
+				}
+				// char への store は切り詰めるので、格納した値をそのまま引き継がない
+				if inst.size != 1 {
+					mem[key] = inst.args[1]
+				}
+				continue
+			case irCall:
+				for k := range mem {
+					if k.v == nil {
+						delete(mem, k)
+					}
+				}
+				continue
+			}
+
+			if inst.dst == 0 || !inst.isPure() {
+				continue
+			}
+			key := exprKey(inst)
+			if v, ok := avail[key]; ok {
+				replace(inst, v)
+			} else {
+				avail[key] = inst.dst
+			}
+		}
+		for _, c := range children[b] {
+			var m map[memKey]int
+			if len(preds[c]) == 1 {
+				m = mem
+			}
+			walk(c, avail, m)
+		}
+	}
+	walk(f.blocks[0], nil, nil)
+
+	for _, b := range f.blocks {
+		b.removeInsts(func(inst *irInst) bool { return !dead[inst] })
+		for _, inst := range b.insts {
+			for i, a := range inst.args {
+				if to, ok := repl[a]; ok {
+					inst.args[i] = to
+				}
+			}
+		}
+	}
+}
+
+// memKey は load/store の対象。ポインタ経由で書き換えられないローカル変数は v で、
+// それ以外はアドレスの仮想レジスタで区別する。
+type memKey struct {
+	v    *obj
+	addr int
+	size int
+}
+
+func memKeyOf(defs []*irInst, escaped map[*obj]bool, addr, size int) memKey {
+	if d := defs[addr]; d != nil && d.op == irLocalAddr && !escaped[d.v] {
+		return memKey{v: d.v, size: size}
+	}
+	return memKey{addr: addr, size: size}
+}
+
+// exprKey は同じ値を計算する命令で等しくなるキーを返す
+func exprKey(inst *irInst) string {
+	args := inst.args
+	switch inst.op {
+	case irAdd, irMul, irEq, irNe:
+		if len(args) == 2 && args[0] > args[1] {
+			args = []int{args[1], args[0]}
+		}
+	}
+	return fmt.Sprintf("%d %v %d %d %p", inst.op, args, inst.imm, inst.size, inst.v)
+}
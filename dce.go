@@ -0,0 +1,98 @@
+package g9cc
+
+import "slices"
+
+// dce は結果を使わない副作用のない命令と、読まれないローカル変数への store を取り除く。
+// 空のブロックを経由する jmp は飛び先を直接指すようにし、到達しないブロックを消し、
+// 一本道でつながるブロックは 1 つにまとめる。
+func dce(f *irFunc) {
+	for changed := true; changed; {
+		changed = false
+		uses := f.useCounts()
+		defs := f.defs()
+		escaped := f.escapedLocals()
+
+		loaded := map[*obj]bool{}
+		for _, b := range f.blocks {
+			for _, inst := range b.insts {
+				if inst.op == irLoad {
+					if d := defs[inst.args[0]]; d != nil && d.op == irLocalAddr {
+						loaded[d.v] = true
+					}
+				}
+			}
+		}
+
+		for _, b := range f.blocks {
+			if b.removeInsts(func(inst *irInst) bool {
+				if inst.dst != 0 && inst.isPure() && uses[inst.dst] == 0 {
+					return false
+				}
+				if inst.op == irStore {
+					d := defs[inst.args[0]]
+					if d != nil && d.op == irLocalAddr && !escaped[d.v] && !loaded[d.v] {
+						return false
+					}
+				}
+				return true
+			}) {
+				changed = true
+			}
+		}
+	}
+	f.threadJumps()
+	f.removeUnreachable()
+	f.mergeBlocks()
+}
+
+// threadJumps は jmp だけのブロックへの分岐を、その飛び先へ直接向ける
+func (f *irFunc) threadJumps() {
+	forward := func(b *irBlock) *irBlock {
+		seen := map[*irBlock]bool{}
+		for len(b.insts) == 1 && b.insts[0].op == irJmp && !seen[b] {
+			seen[b] = true
+			b = b.insts[0].then
+		}
+		return b
+	}
+	for _, b := range f.blocks {
+		if !b.terminated() {
+			continue
+		}
+		last := b.insts[len(b.insts)-1]
+		switch last.op {
+		case irJmp:
+			last.then = forward(last.then)
+		case irBr:
+			last.then, last.els = forward(last.then), forward(last.els)
+			if last.then == last.els {
+				*last = irInst{op: irJmp, then: last.then, tok: last.tok}
+			}
+		}
+	}
+}
+
+// mergeBlocks は jmp で終わるブロックと、そこからしか来ない飛び先のブロックをつなげる
+func (f *irFunc) mergeBlocks() {
+	for changed := true; changed; {
+		changed = false
+		preds := f.preds()
+		for _, b := range f.blocks {
+			last := b.insts[len(b.insts)-1]
+			if last.op != irJmp {
+				continue
+			}
+			t := last.then
+			if t == b || t == f.blocks[0] || len(preds[t]) != 1 {
+				continue
+			}
+			b.insts = append(b.insts[:len(b.insts)-1], t.insts...)
+			t.insts = nil
+			changed = true
+			break
+		}
+		if changed {
+			f.blocks = slices.DeleteFunc(f.blocks, func(b *irBlock) bool { return len(b.insts) == 0 })
+		}
+	}
+}
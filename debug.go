@@ -0,0 +1,361 @@
+package g9cc
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// デバッグ情報の出力（-g）。
+// 行番号は .file/.loc でアセンブラに任せ（.debug_line はアセンブラが作る）、
+// 呼び出しフレームは .cfi_* で書く（.eh_frame もアセンブラが作る）。
+// 変数の情報は DWARF 4 の .debug_info/.debug_abbrev を直接書く。
+// ローカル変数の場所はフレームベース（rbp）からのオフセットで表す。
+
+// debugInfo は -g のときのコード生成の状態
+type debugInfo struct {
+	lastLine int // 最後に .loc を出した位置
+	lastCol  int
+	funcs    []*obj         // コードを出した関数（出した順）
+	types    map[string]int // 型の文字列 -> DIE の番号
+	typeList []*ty          // DIE を出す型（番号順）
+}
+
+// loc は tok の位置の .loc を出す（文の先頭で使う）
+func (g *generator) loc(tok *token) {
+	if g.dbg == nil || tok == nil {
+		return
+	}
+	line, col := g.src.position(tok)
+	if line == g.dbg.lastLine && col == g.dbg.lastCol {
+		return
+	}
+	g.dbg.lastLine, g.dbg.lastCol = line, col
+	g.debugDirective(".loc 1 %d %d", line, col)
+}
+
+// lineLoc は行が変わったときだけ .loc を出す（IR の命令ごとに使う）
+func (g *generator) lineLoc(tok *token) {
+	if g.dbg == nil || tok == nil {
+		return
+	}
+	if line, _ := g.src.position(tok); line != g.dbg.lastLine {
+		g.loc(tok)
+	}
+}
+
+// pushFrame は rbp のフレームを作る。-g なら CFA（呼び出し直前の rsp）の求め方の変化を書く。
+func (g *generator) pushFrame() {
+	g.emit("push", regOp("rbp"))
+	g.debugDirective(".cfi_def_cfa_offset 16")
+	g.debugDirective(".cfi_offset %s, -16", regOp("rbp").format(g.syntax))
+	g.emit("mov", regOp("rbp"), regOp("rsp"))
+	g.debugDirective(".cfi_def_cfa_register %s", regOp("rbp").format(g.syntax))
+}
+
+// popFrame は rbp のフレームを畳む
+func (g *generator) popFrame() {
+	g.emit("mov", regOp("rsp"), regOp("rbp"))
+	g.emit("pop", regOp("rbp"))
+	g.debugDirective(".cfi_def_cfa %s, 8", regOp("rsp").format(g.syntax))
+}
+
+// funcStart は関数のラベルを置く
+func (g *generator) funcStart(fn *obj) {
+	g.label("%s", *fn.name)
+	if g.dbg != nil {
+		g.dbg.funcs = append(g.dbg.funcs, fn)
+		g.loc(fn.tok)
+	}
+	g.debugDirective(".cfi_startproc")
+	g.commentPrologue(fn)
+}
+
+// funcEnd は関数の終わりを書く。-g なら .debug_info から参照する終わりのラベルも置く。
+func (g *generator) funcEnd(fn *obj) {
+	g.debugDirective(".cfi_endproc")
+	if g.dbg != nil {
+		g.label(".L.%s.end", *fn.name)
+	}
+	g.directive(".size %s, .-%s", *fn.name, *fn.name)
+}
+
+// DWARF の定数
+const (
+	dwTagArrayType    = 0x01
+	dwTagFormalParam  = 0x05
+	dwTagPointerType  = 0x0f
+	dwTagCompileUnit  = 0x11
+	dwTagSubrangeType = 0x21
+	dwTagBaseType     = 0x24
+	dwTagConstType    = 0x26
+	dwTagSubprogram   = 0x2e
+	dwTagVariable     = 0x34
+	dwAtLocation      = 0x02
+	dwAtName          = 0x03
+	dwAtByteSize      = 0x0b
+	dwAtStmtList      = 0x10
+	dwAtLowPC         = 0x11
+	dwAtHighPC        = 0x12
+	dwAtLanguage      = 0x13
+	dwAtProducer      = 0x25
+	dwAtUpperBound    = 0x2f
+	dwAtDeclFile      = 0x3a
+	dwAtDeclLine      = 0x3b
+	dwAtEncoding      = 0x3e
+	dwAtExternal      = 0x3f
+	dwAtFrameBase     = 0x40
+	dwAtType          = 0x49
+	dwFormAddr        = 0x01
+	dwFormData4       = 0x06
+	dwFormData8       = 0x07
+	dwFormString      = 0x08
+	dwFormData1       = 0x0b
+	dwFormFlag        = 0x0c
+	dwFormRef4        = 0x13
+	dwFormSecOffset   = 0x17
+	dwFormExprloc     = 0x18
+	dwAteSigned       = 0x05
+	dwAteSignedChar   = 0x06
+	dwLangC99         = 0x0c
+	dwOpAddr          = 0x03
+	dwOpBreg6         = 0x76 // rbp
+	dwOpFbreg         = 0x91
+)
+
+// abbrev は .debug_abbrev の 1 項目
+type abbrev struct {
+	tag      int
+	children bool
+	attrs    [][2]int // 属性と形式
+}
+
+// DIE の種類ごとの略記（添字 + 1 が略記の番号）
+const (
+	abbrevCompileUnit = iota + 1
+	abbrevBaseType
+	abbrevPointerType
+	abbrevArrayType
+	abbrevSubrangeType
+	abbrevConstType
+	abbrevSubprogram
+	abbrevParam
+	abbrevLocalVar
+	abbrevGlobalVar
+)
+
+var abbrevs = []abbrev{
+	{dwTagCompileUnit, true, [][2]int{{dwAtProducer, dwFormString}, {dwAtLanguage, dwFormData1}, {dwAtName, dwFormString},
+		{dwAtLowPC, dwFormAddr}, {dwAtHighPC, dwFormData8}, {dwAtStmtList, dwFormSecOffset}}},
+	{dwTagBaseType, false, [][2]int{{dwAtByteSize, dwFormData1}, {dwAtEncoding, dwFormData1}, {dwAtName, dwFormString}}},
+	{dwTagPointerType, false, [][2]int{{dwAtByteSize, dwFormData1}, {dwAtType, dwFormRef4}}},
+	{dwTagArrayType, true, [][2]int{{dwAtType, dwFormRef4}}},
+	{dwTagSubrangeType, false, [][2]int{{dwAtType, dwFormRef4}, {dwAtUpperBound, dwFormData4}}},
+	{dwTagConstType, false, [][2]int{{dwAtType, dwFormRef4}}},
+	{dwTagSubprogram, true, [][2]int{{dwAtExternal, dwFormFlag}, {dwAtName, dwFormString}, {dwAtDeclFile, dwFormData1},
+		{dwAtDeclLine, dwFormData4}, {dwAtType, dwFormRef4}, {dwAtLowPC, dwFormAddr}, {dwAtHighPC, dwFormData8},
+		{dwAtFrameBase, dwFormExprloc}}},
+	{dwTagFormalParam, false, [][2]int{{dwAtName, dwFormString}, {dwAtDeclFile, dwFormData1}, {dwAtDeclLine, dwFormData4},
+		{dwAtType, dwFormRef4}, {dwAtLocation, dwFormExprloc}}},
+	{dwTagVariable, false, [][2]int{{dwAtName, dwFormString}, {dwAtDeclFile, dwFormData1}, {dwAtDeclLine, dwFormData4},
+		{dwAtType, dwFormRef4}, {dwAtLocation, dwFormExprloc}}},
+	{dwTagVariable, false, [][2]int{{dwAtName, dwFormString}, {dwAtDeclFile, dwFormData1}, {dwAtDeclLine, dwFormData4},
+		{dwAtType, dwFormRef4}, {dwAtExternal, dwFormFlag}, {dwAtLocation, dwFormExprloc}}},
+}
+
+// fileDirective は .file を書く（出力の先頭で使う）
+func (g *generator) fileDirective() {
+	if g.dbg != nil {
+		g.directive(".file 1 %s", strconv.Quote(g.src.filename))
+	}
+}
+
+// typeRef は型 t の DIE への参照（.debug_info の先頭からのオフセット）を書く
+func (g *generator) typeRef(t *ty) {
+	key := t.String()
+	n, ok := g.dbg.types[key]
+	if !ok {
+		n = len(g.dbg.typeList)
+		g.dbg.types[key] = n
+		g.dbg.typeList = append(g.dbg.typeList, t)
+	}
+	g.directive("    .long .Ldebug_type%d - .Ldebug_info0", n)
+}
+
+// emitDebugInfo は .debug_abbrev と .debug_info を書く（.text の最後で呼ぶ）
+func (g *generator) emitDebugInfo(prog *obj) {
+	if g.dbg == nil {
+		return
+	}
+	g.label(".Letext0")
+
+	g.directive(".section .debug_abbrev,\"\",@progbits")
+	g.label(".Ldebug_abbrev0")
+	for i, a := range abbrevs {
+		g.directive("    .uleb128 %d", i+1)
+		g.directive("    .uleb128 %#x", a.tag)
+		g.directive("    .byte %d", flag(a.children))
+		for _, attr := range a.attrs {
+			g.directive("    .uleb128 %#x", attr[0])
+			g.directive("    .uleb128 %#x", attr[1])
+		}
+		g.directive("    .byte 0")
+		g.directive("    .byte 0")
+	}
+	g.directive("    .byte 0")
+
+	g.directive(".section .debug_info,\"\",@progbits")
+	g.label(".Ldebug_info0")
+	g.directive("    .long .Ldebug_info_end - .Ldebug_info_start")
+	g.label(".Ldebug_info_start")
+	g.directive("    .value 4") // DWARF のバージョン
+	g.directive("    .long .Ldebug_abbrev0")
+	g.directive("    .byte 8") // アドレスの大きさ
+
+	g.directive("    .uleb128 %d", abbrevCompileUnit)
+	g.directive("    .string \"g9cc\"")
+	g.directive("    .byte %#x", dwLangC99)
+	g.directive("    .string %s", strconv.Quote(g.src.filename))
+	g.directive("    .quad .Ltext0")
+	g.directive("    .quad .Letext0 - .Ltext0")
+	g.directive("    .long .Ldebug_line0")
+
+	for _, fn := range g.dbg.funcs {
+		g.debugFunc(fn)
+	}
+	for v := prog; v != nil; v = v.next {
+		if v.isFunction || v.isExtern || isStringLiteral(v) {
+			continue
+		}
+		g.debugVar(abbrevGlobalVar, v, func() {
+			g.directive("    .byte %d", flag(!v.isStatic))
+			g.directive("    .uleb128 9")
+			g.directive("    .byte %#x", dwOpAddr)
+			g.directive("    .quad %s", *v.name)
+		})
+	}
+	// 型は参照されたものだけを出す（出している間に要素の型が増えることがある）
+	for i := 0; i < len(g.dbg.typeList); i++ {
+		g.debugType(i, g.dbg.typeList[i])
+	}
+	g.directive("    .byte 0")
+	g.label(".Ldebug_info_end")
+
+	// .loc からアセンブラが作る行番号表の先頭
+	g.directive(".section .debug_line,\"\",@progbits")
+	g.label(".Ldebug_line0")
+}
+
+// debugFunc は関数の DIE とその引数・ローカル変数の DIE を書く
+func (g *generator) debugFunc(fn *obj) {
+	line := 0
+	if fn.tok != nil {
+		line, _ = g.src.position(fn.tok)
+	}
+	retTy := intType()
+	if fn.ty != nil && fn.ty.returnTy != nil {
+		retTy = fn.ty.returnTy
+	}
+	g.directive("    .uleb128 %d", abbrevSubprogram)
+	g.directive("    .byte %d", flag(!fn.isStatic))
+	g.directive("    .string %s", strconv.Quote(*fn.name))
+	g.directive("    .byte 1")
+	g.directive("    .long %d", line)
+	g.typeRef(retTy)
+	g.directive("    .quad %s", *fn.name)
+	g.directive("    .quad .L.%s.end - %s", *fn.name, *fn.name)
+	g.directive("    .uleb128 2")
+	g.directive("    .byte %#x, 0", dwOpBreg6)
+
+	params, locals := frameVars(fn)
+	// インライン展開で複製した変数（"callee.x"）は出さない
+	locals = slices.DeleteFunc(locals, func(v *obj) bool { return strings.Contains(*v.name, ".") })
+
+	frameLoc := func(v *obj) func() {
+		return func() {
+			g.directive("    .uleb128 %d", 1+slebLen(-v.offset))
+			g.directive("    .byte %#x", dwOpFbreg)
+			g.directive("    .sleb128 %d", -v.offset)
+		}
+	}
+	for _, v := range params {
+		g.debugVar(abbrevParam, v, frameLoc(v))
+	}
+	for _, v := range locals {
+		g.debugVar(abbrevLocalVar, v, frameLoc(v))
+	}
+	g.directive("    .byte 0")
+}
+
+// debugVar は変数の DIE を名前・宣言位置・型まで書き、残り（場所など）を rest で書く
+func (g *generator) debugVar(code int, v *obj, rest func()) {
+	line := 0
+	if v.tok != nil {
+		line, _ = g.src.position(v.tok)
+	}
+	g.directive("    .uleb128 %d", code)
+	g.directive("    .string %s", strconv.Quote(*v.name))
+	g.directive("    .byte 1")
+	g.directive("    .long %d", line)
+	g.typeRef(v.ty)
+	rest()
+}
+
+// debugType は n 番目の型の DIE を書く
+func (g *generator) debugType(n int, t *ty) {
+	g.label(".Ldebug_type%d", n)
+	switch {
+	case t.isConst:
+		base := *t
+		base.isConst = false
+		g.directive("    .uleb128 %d", abbrevConstType)
+		g.typeRef(&base)
+	case t.kind == tyInt, t.kind == tyChar:
+		enc, name := dwAteSigned, "int"
+		if t.kind == tyChar {
+			enc, name = dwAteSignedChar, "char"
+		}
+		g.directive("    .uleb128 %d", abbrevBaseType)
+		g.directive("    .byte %d", t.size)
+		g.directive("    .byte %#x", enc)
+		g.directive("    .string \"%s\"", name)
+	case t.kind == tyPtr:
+		g.directive("    .uleb128 %d", abbrevPointerType)
+		g.directive("    .byte 8")
+		g.typeRef(t.base)
+	case t.kind == tyArray:
+		g.directive("    .uleb128 %d", abbrevArrayType)
+		g.typeRef(t.base)
+		g.directive("    .uleb128 %d", abbrevSubrangeType)
+		g.typeRef(intType())
+		g.directive("    .long %d", t.arrayLen-1)
+		g.directive("    .byte 0")
+	default:
+		// 変数の型はここまでのどれかになる
+		g.directive("    .uleb128 %d", abbrevBaseType)
+		g.directive("    .byte %d", t.size)
+		g.directive("    .byte %#x", dwAteSigned)
+		g.directive("    .string %s", strconv.Quote(t.String()))
+	}
+}
+
+// flag は DW_FORM_flag の値を返す
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// slebLen は v を符号付き LEB128 で書いたときのバイト数を返す
+func slebLen(v int) int {
+	n := 1
+	for {
+		b := v & 0x7f
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			return n
+		}
+		n++
+	}
+}
@@ -1,17 +1,162 @@
-package main
+package g9cc
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
-func errorAt(input string, pos int, msg string) error {
+// Severity は診断の重大度
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Position はソース上の位置（Line, Col は1始まり）
+type Position struct {
+	Filename string `json:"filename,omitempty"`
+	Offset   int    `json:"offset"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+}
+
+func (p Position) String() string {
+	name := p.Filename
+	if name == "" {
+		name = "<input>"
+	}
+	return fmt.Sprintf("%s:%d:%d", name, p.Line, p.Col)
+}
+
+// Diagnostic は位置付きのエラー・警告
+type Diagnostic struct {
+	Severity Severity
+	Pos      Position
+	Msg      string
+	Source   string       // Pos を含む行の本文
+	Category string       // 警告カテゴリ（-Werror でエラーになった警告も含む）
+	Notes    []Diagnostic // 関連する位置の補足（宣言位置など）
+}
+
+func (d *Diagnostic) Error() string {
+	// キャレットの前はタブをそのまま残して列を揃える
+	prefix := []byte(d.Source[:min(d.Pos.Col-1, len(d.Source))])
+	for i, b := range prefix {
+		if b != '\t' {
+			prefix[i] = ' '
+		}
+	}
+	msg := d.Msg
+	if d.Category != "" {
+		if d.Severity == SeverityError {
+			msg += fmt.Sprintf(" [-Werror=%s]", d.Category)
+		} else {
+			msg += fmt.Sprintf(" [-W%s]", d.Category)
+		}
+	}
+	out := fmt.Sprintf("%s: %s: %s\n%s\n%s^", d.Pos, d.Severity, msg, d.Source, prefix)
+	for i := range d.Notes {
+		out += "\n" + d.Notes[i].Error()
+	}
+	return out
+}
+
+// position は input 中のバイト位置 pos を行・列に変換する
+func position(input string, pos int) (Position, string) {
 	if pos < 0 {
 		pos = 0
 	}
 	if pos > len(input) {
 		pos = len(input)
 	}
-	caret := strings.Repeat(" ", pos) + "^"
-	return fmt.Errorf("%s\n%s %s", input, caret, msg)
+	start := strings.LastIndexByte(input[:pos], '\n') + 1
+	end := strings.IndexByte(input[pos:], '\n')
+	if end < 0 {
+		end = len(input)
+	} else {
+		end += pos
+	}
+	line := strings.Count(input[:start], "\n") + 1
+	return Position{Offset: pos, Line: line, Col: pos - start + 1}, input[start:end]
+}
+
+func errorAt(input string, pos int, msg string) error {
+	p, src := position(input, pos)
+	return &Diagnostic{Severity: SeverityError, Pos: p, Msg: msg, Source: src}
+}
+
+// errorTok はトークンの位置を指すエラーを返す
+func errorTok(input string, tok *token, msg string) error {
+	if tok == nil {
+		return errorAt(input, 0, msg)
+	}
+	return errorAt(input, tok.pos, msg)
+}
+
+// errTooManyErrors は -fmax-errors の上限に達して解析を打ち切ったことを表す
+var errTooManyErrors = errors.New("too many errors")
+
+// diagSink は1回のコンパイルで出た診断を集める
+type diagSink struct {
+	diags     []*Diagnostic
+	nerrors   int
+	nwerrors  int             // nerrors のうち -Werror で警告から変えたもの
+	maxErrors int             // 0 なら無制限
+	warnings  map[string]bool // 有効な警告カテゴリ
+	werror    bool            // 警告をエラーとして扱う
+}
+
+// report は回復可能なエラーを記録する。位置を持たない内部エラーはそのまま返し、
+// 上限に達したら errTooManyErrors を返す。
+func (s *diagSink) report(err error) error {
+	var d *Diagnostic
+	if !errors.As(err, &d) {
+		return err
+	}
+	s.diags = append(s.diags, d)
+	s.nerrors++
+	if s.maxErrors > 0 && s.nerrors >= s.maxErrors {
+		return errTooManyErrors
+	}
+	return nil
+}
+
+// warn は有効なカテゴリの警告を記録する。-Werror のときはエラーとして report する。
+func (s *diagSink) warn(category, input string, pos int, msg string) error {
+	return s.warnWithNote(category, input, pos, msg, -1, "")
+}
+
+// warnWithNote は notePos の位置を指す補足付きの警告を記録する（notePos < 0 なら補足なし）
+func (s *diagSink) warnWithNote(category, input string, pos int, msg string, notePos int, note string) error {
+	if !s.warnings[category] {
+		return nil
+	}
+	p, src := position(input, pos)
+	d := &Diagnostic{Severity: SeverityWarning, Pos: p, Msg: msg, Source: src, Category: category}
+	if notePos >= 0 {
+		np, nsrc := position(input, notePos)
+		d.Notes = []Diagnostic{{Severity: SeverityNote, Pos: np, Msg: note, Source: nsrc}}
+	}
+	if s.werror {
+		d.Severity = SeverityError
+		s.nwerrors++
+		return s.report(d)
+	}
+	s.diags = append(s.diags, d)
+	return nil
 }
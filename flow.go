@@ -0,0 +1,88 @@
+package g9cc
+
+// 文の制御フローを AST 上で調べ、関数末尾への到達と到達不能な文を検出する。
+
+// isEmptyStmt はコードを生成しない文（";" や初期化子のない宣言）かどうかを返す
+func isEmptyStmt(n *node) bool {
+	if n.kind != ndBlock {
+		return false
+	}
+	for stmt := n.lhs; stmt != nil; stmt = stmt.next {
+		if !isEmptyStmt(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAlwaysTrue は条件が定数で常に真かどうかを返す（省略された for の条件も含む）
+func isAlwaysTrue(cond *node) bool {
+	if cond == nil {
+		return true
+	}
+	val, ok := constValue(cond)
+	return ok && val != 0
+}
+
+// fallsThrough は文の実行が次の文へ進みうるかどうかを返す。
+// ブロック中で return の後に続く文は到達不能として警告する。
+func (c *checker) fallsThrough(n *node) (bool, error) {
+	switch n.kind {
+	case ndReturn:
+		return false, nil
+	case ndBlock:
+		for stmt := n.lhs; stmt != nil; stmt = stmt.next {
+			ok, err := c.fallsThrough(stmt)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				continue
+			}
+			for rest := stmt.next; rest != nil; rest = rest.next {
+				if !isEmptyStmt(rest) {
+					return false, c.warn(WarnUnreachableCode, rest, "code will never be executed")
+				}
+			}
+			return false, nil
+		}
+		return true, nil
+	case ndIf:
+		then, err := c.fallsThrough(n.then)
+		if err != nil || n.els == nil {
+			return true, err
+		}
+		// then が抜けても else の中の到達不能な文は調べる
+		els, err := c.fallsThrough(n.els)
+		return then || els, err
+	case ndWhile:
+		// break がないので、条件が常に真のループからは抜けられない
+		if _, err := c.fallsThrough(n.rhs); err != nil {
+			return false, err
+		}
+		return !isAlwaysTrue(n.lhs), nil
+	case ndFor:
+		if n.then != nil {
+			if _, err := c.fallsThrough(n.then); err != nil {
+				return false, err
+			}
+		}
+		return !isAlwaysTrue(n.cond), nil
+	}
+	return true, nil
+}
+
+// checkFlow は関数末尾に到達しうるかを調べる。main は C99 に従い 0 を返すよう
+// returnsZero を立て（AST は変えない）、それ以外の関数には警告を出す。
+func (c *checker) checkFlow(fn *obj) error {
+	reachesEnd, err := c.fallsThrough(fn.body)
+	if err != nil || !reachesEnd {
+		return err
+	}
+
+	if *fn.name == "main" {
+		fn.returnsZero = true
+		return nil
+	}
+	return c.diags.warn(WarnReturnType, c.input, fn.tok.pos, "control reaches end of non-void function '"+*fn.name+"'")
+}
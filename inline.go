@@ -0,0 +1,224 @@
+package g9cc
+
+import "slices"
+
+// 関数のインライン展開（-O2）。
+// 同じ翻訳単位で定義された再帰しない小さな関数の呼び出しを、その本体の IR の複製で置き換える。
+// 呼び出し先のローカル変数（引数を含む）は呼び出し元のフレームの後ろに複製して置き、
+// 引数はそこへの store として渡す。
+
+const (
+	inlineLimit     = 20  // インライン展開する関数の命令数の上限
+	inlineHintLimit = 200 // inline 指定がある関数の命令数の上限
+)
+
+func inlineCalls(p *irProgram) {
+	funcs := map[string]*irFunc{}
+	for _, f := range p.funcs {
+		funcs[f.name()] = f
+	}
+	recursive := map[*irFunc]bool{}
+	for _, f := range p.funcs {
+		recursive[f] = f.reaches(f, funcs, map[*irFunc]bool{})
+	}
+
+	// 呼び出される側から先に展開し、展開した後の大きさで判断する
+	done := map[*irFunc]bool{}
+	var visit func(f *irFunc)
+	visit = func(f *irFunc) {
+		if done[f] {
+			return
+		}
+		done[f] = true
+		for _, callee := range f.callees(funcs) {
+			visit(callee)
+		}
+		f.inlineCalls(funcs, recursive)
+	}
+	for _, f := range p.funcs {
+		visit(f)
+	}
+}
+
+// callees は f が呼び出す同じ翻訳単位の関数を返す
+func (f *irFunc) callees(funcs map[string]*irFunc) []*irFunc {
+	var out []*irFunc
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			if callee := funcs[inst.sym]; inst.op == irCall && callee != nil && !slices.Contains(out, callee) {
+				out = append(out, callee)
+			}
+		}
+	}
+	return out
+}
+
+// reaches は f から呼び出しをたどって target に届くかどうかを返す
+func (f *irFunc) reaches(target *irFunc, funcs map[string]*irFunc, seen map[*irFunc]bool) bool {
+	for _, callee := range f.callees(funcs) {
+		if callee == target {
+			return true
+		}
+		if !seen[callee] {
+			seen[callee] = true
+			if callee.reaches(target, funcs, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *irFunc) size() int {
+	n := 0
+	for _, b := range f.blocks {
+		n += len(b.insts)
+	}
+	return n
+}
+
+// localSize はローカル変数が使うフレームの大きさを返す
+func (f *irFunc) localSize() int {
+	size := 0
+	for v := f.fn.locals; v != nil; v = v.next {
+		size = max(size, v.offset)
+	}
+	return alignTo(size, 8)
+}
+
+// inlinable は call を callee の本体で置き換えてよいかどうかを返す
+func (callee *irFunc) inlinable(call *irInst) bool {
+	nparams := 0
+	for v := callee.fn.params; v != nil; v = v.next {
+		nparams++
+	}
+	if nparams != len(call.args) {
+		return false
+	}
+	if callee.fn.isInline {
+		return callee.size() <= inlineHintLimit
+	}
+	return callee.size() <= inlineLimit
+}
+
+func (f *irFunc) inlineCalls(funcs map[string]*irFunc, recursive map[*irFunc]bool) {
+	// 展開した本体は後ろのブロックに置くので、そこも続けて調べることになる
+	for i := 0; i < len(f.blocks); i++ {
+		for j, inst := range f.blocks[i].insts {
+			callee := funcs[inst.sym]
+			if inst.op != irCall || callee == nil || callee == f || recursive[callee] || !callee.inlinable(inst) {
+				continue
+			}
+			f.inlineAt(i, j, callee)
+			break
+		}
+	}
+	f.renumber()
+}
+
+// inlineAt は blocks[bi] の j 番目の命令（call）を callee の本体で置き換える。
+// ブロックは call の前後で分け、後ろ半分は callee の ret から jmp する新しいブロックにする。
+func (f *irFunc) inlineAt(bi, j int, callee *irFunc) {
+	b := f.blocks[bi]
+	call := b.insts[j]
+	cont := &irBlock{insts: slices.Clone(b.insts[j+1:])}
+	b.insts = b.insts[:j]
+
+	// 呼び出し先のローカル変数を複製する（名前は "callee.x"）
+	base := f.localSize()
+	vars := map[*obj]*obj{}
+	for v := callee.fn.locals; v != nil; v = v.next {
+		nv := *v
+		name := callee.name() + "." + *v.name
+		nv.name = &name
+		nv.offset = base + v.offset
+		nv.next = f.fn.locals
+		f.fn.locals = &nv
+		vars[v] = &nv
+	}
+
+	regs := make([]int, len(callee.types))
+	for r := 1; r < len(callee.types); r++ {
+		regs[r] = f.newReg(callee.types[r])
+	}
+	blocks := map[*irBlock]*irBlock{}
+	var body []*irBlock
+	for _, cb := range callee.blocks {
+		blocks[cb] = &irBlock{}
+		body = append(body, blocks[cb])
+	}
+
+	// 引数を複製した引数の変数に格納して本体の入口へ
+	i := 0
+	for v := callee.fn.params; v != nil; v = v.next {
+		addr := f.newReg(irPtr)
+		b.insts = append(b.insts,
+			&irInst{op: irLocalAddr, dst: addr, v: vars[v], tok: call.tok},
+			&irInst{op: irStore, args: []int{addr, call.args[i]}, size: v.ty.size, tok: call.tok})
+		i++
+	}
+	b.insts = append(b.insts, &irInst{op: irJmp, then: body[0], tok: call.tok})
+
+	// ret が複数あれば戻り値は変数を通して cont に渡す
+	nrets := 0
+	for _, cb := range callee.blocks {
+		if last := cb.insts[len(cb.insts)-1]; last.op == irRet {
+			nrets++
+		}
+	}
+	var retVar *obj
+	result := 0
+	if nrets > 1 {
+		name := callee.name() + ".ret"
+		retVar = &obj{name: &name, ty: intType(), isLocal: true, offset: alignTo(base+callee.localSize()+4, 8), next: f.fn.locals}
+		f.fn.locals = retVar
+		addr := f.newReg(irPtr)
+		cont.insts = slices.Insert(cont.insts, 0,
+			&irInst{op: irLocalAddr, dst: addr, v: retVar, tok: call.tok},
+			&irInst{op: irLoad, dst: call.dst, args: []int{addr}, size: retVar.ty.size, tok: call.tok})
+	}
+
+	for _, cb := range callee.blocks {
+		nb := blocks[cb]
+		for _, inst := range cb.insts {
+			if inst.op != irRet {
+				ni := *inst
+				ni.dst = regs[inst.dst]
+				ni.args = make([]int, len(inst.args))
+				for k, a := range inst.args {
+					ni.args[k] = regs[a]
+				}
+				if inst.op == irLocalAddr {
+					ni.v = vars[inst.v]
+				}
+				ni.then, ni.els = blocks[inst.then], blocks[inst.els]
+				nb.insts = append(nb.insts, &ni)
+				continue
+			}
+
+			// 値のない return は 0 を返したことにする
+			var val int
+			if len(inst.args) > 0 {
+				val = regs[inst.args[0]]
+			} else {
+				val = f.newReg(irI32)
+				nb.insts = append(nb.insts, &irInst{op: irImm, dst: val, tok: inst.tok})
+			}
+			if retVar != nil {
+				addr := f.newReg(irPtr)
+				nb.insts = append(nb.insts,
+					&irInst{op: irLocalAddr, dst: addr, v: retVar, tok: inst.tok},
+					&irInst{op: irStore, args: []int{addr, val}, size: retVar.ty.size, tok: inst.tok})
+			} else {
+				// ret が 1 つだけならそのブロックは cont を支配するので、値をそのまま使える
+				result = val
+			}
+			nb.insts = append(nb.insts, &irInst{op: irJmp, then: cont, tok: inst.tok})
+		}
+	}
+
+	f.blocks = slices.Insert(f.blocks, bi+1, append(body, cont)...)
+	if result != 0 {
+		f.replaceUses(call.dst, result)
+	}
+}
@@ -0,0 +1,242 @@
+package g9cc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 型付き AST と x86-64 コード生成の間に置く三番地コードの中間表現（IR）。
+// 値は関数ごとに番号を振った仮想レジスタ（%N）に入れ、
+// 関数は基本ブロックの列で、ブロックの末尾は必ず jmp/br/ret で終わる。
+
+type irOp int
+
+const (
+	irImm        irOp = iota // dst = imm
+	irLocalAddr              // dst = &local
+	irGlobalAddr             // dst = &global
+	irLoad                   // dst = *args[0]（size バイト、符号拡張）
+	irStore                  // *args[0] = args[1]（size バイト）
+	irMov                    // dst = args[0]
+	irAdd                    // dst = args[0] + args[1]
+	irSub                    // dst = args[0] - args[1]
+	irMul                    // dst = args[0] * args[1]
+	irDiv                    // dst = args[0] / args[1]
+	irEq                     // dst = args[0] == args[1]
+	irNe                     // dst = args[0] != args[1]
+	irLt                     // dst = args[0] < args[1]
+	irLe                     // dst = args[0] <= args[1]
+	irShl                    // dst = args[0] << imm（-O で乗算から作る）
+	irLea                    // dst = args[0] + args[1]<<imm（-O でポインタ演算から作る）
+	irCall                   // dst = sym(args...)
+	irJmp                    // goto then
+	irBr                     // if args[0] != 0 goto then else els
+	irRet                    // return args[0]（args が空なら値なし）
+	irTailCall               // return sym(args...)（-O2 で call と ret から作る。フレームを畳んで jmp する）
+)
+
+var irOpNames = [...]string{
+	irImm:        "imm",
+	irLocalAddr:  "local",
+	irGlobalAddr: "global",
+	irLoad:       "load",
+	irStore:      "store",
+	irMov:        "mov",
+	irAdd:        "add",
+	irSub:        "sub",
+	irMul:        "mul",
+	irDiv:        "div",
+	irEq:         "eq",
+	irNe:         "ne",
+	irLt:         "lt",
+	irLe:         "le",
+	irShl:        "shl",
+	irLea:        "lea",
+	irCall:       "call",
+	irJmp:        "jmp",
+	irBr:         "br",
+	irRet:        "ret",
+	irTailCall:   "tailcall",
+}
+
+func (op irOp) String() string {
+	if int(op) < len(irOpNames) {
+		return irOpNames[op]
+	}
+	return fmt.Sprintf("irOp(%d)", int(op))
+}
+
+// isTerminator はブロックを終える命令かどうかを返す
+func (op irOp) isTerminator() bool {
+	return op == irJmp || op == irBr || op == irRet || op == irTailCall
+}
+
+// irType は仮想レジスタの型。レジスタ上ではどれも 64 ビットに符号拡張して持つ。
+type irType int
+
+const (
+	irI8 irType = iota
+	irI32
+	irPtr
+)
+
+func (t irType) String() string {
+	switch t {
+	case irI8:
+		return "i8"
+	case irI32:
+		return "i32"
+	case irPtr:
+		return "ptr"
+	}
+	return fmt.Sprintf("irType(%d)", int(t))
+}
+
+// irTypeOf は AST の型に対応する IR の型を返す
+func irTypeOf(t *ty) irType {
+	switch t.kind {
+	case tyChar:
+		return irI8
+	case tyPtr, tyArray:
+		return irPtr
+	}
+	return irI32
+}
+
+type irInst struct {
+	op   irOp
+	dst  int   // 結果の仮想レジスタ（なければ 0）
+	args []int // オペランドの仮想レジスタ
+	imm  int   // irImm の値、irShl/irLea のシフト量
+	size int   // irLoad/irStore のバイト数
+	v    *obj  // irLocalAddr/irGlobalAddr の変数
+	sym  string
+	then *irBlock
+	els  *irBlock
+	tok  *token // 元になった AST ノードのトークン
+}
+
+type irBlock struct {
+	id    int
+	insts []*irInst
+}
+
+func (b *irBlock) terminated() bool {
+	return len(b.insts) > 0 && b.insts[len(b.insts)-1].op.isTerminator()
+}
+
+// succs は後続ブロックを返す
+func (b *irBlock) succs() []*irBlock {
+	if !b.terminated() {
+		return nil
+	}
+	last := b.insts[len(b.insts)-1]
+	switch last.op {
+	case irJmp:
+		return []*irBlock{last.then}
+	case irBr:
+		return []*irBlock{last.then, last.els}
+	}
+	return nil
+}
+
+type irFunc struct {
+	fn     *obj
+	blocks []*irBlock // 先頭が入口
+	types  []irType   // 仮想レジスタの型（添字が番号、0 番は未使用）
+}
+
+func (f *irFunc) name() string {
+	return *f.fn.name
+}
+
+type irProgram struct {
+	funcs []*irFunc
+	prog  *obj // グローバル変数の出力に使う
+}
+
+// 0 番は「結果なし」を表すので仮想レジスタは 1 から振る
+func (f *irFunc) newReg(t irType) int {
+	if len(f.types) == 0 {
+		f.types = append(f.types, irI32)
+	}
+	f.types = append(f.types, t)
+	return len(f.types) - 1
+}
+
+func (f *irFunc) newBlock() *irBlock {
+	b := &irBlock{}
+	f.blocks = append(f.blocks, b)
+	return b
+}
+
+// renumber はブロックに出現順の番号を振り直す
+func (f *irFunc) renumber() {
+	for i, b := range f.blocks {
+		b.id = i
+	}
+}
+
+func (inst *irInst) String() string {
+	var sb strings.Builder
+	reg := func(r int) string { return fmt.Sprintf("%%%d", r) }
+	args := make([]string, len(inst.args))
+	for i, a := range inst.args {
+		args[i] = reg(a)
+	}
+
+	switch inst.op {
+	case irImm:
+		fmt.Fprintf(&sb, "imm %d", inst.imm)
+	case irLocalAddr, irGlobalAddr:
+		fmt.Fprintf(&sb, "%s %s", inst.op, *inst.v.name)
+	case irShl:
+		fmt.Fprintf(&sb, "shl %s, %d", args[0], inst.imm)
+	case irLea:
+		fmt.Fprintf(&sb, "lea %s, %s, %d", args[0], args[1], 1<<inst.imm)
+	case irLoad:
+		fmt.Fprintf(&sb, "load.%d %s", inst.size, args[0])
+	case irStore:
+		fmt.Fprintf(&sb, "store.%d %s, %s", inst.size, args[0], args[1])
+	case irCall, irTailCall:
+		fmt.Fprintf(&sb, "%s %s(%s)", inst.op, inst.sym, strings.Join(args, ", "))
+	case irJmp:
+		fmt.Fprintf(&sb, "jmp bb%d", inst.then.id)
+	case irBr:
+		fmt.Fprintf(&sb, "br %s, bb%d, bb%d", args[0], inst.then.id, inst.els.id)
+	default:
+		fmt.Fprintf(&sb, "%s %s", inst.op, strings.Join(args, ", "))
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// dump は IR をテキストで出力する（-emit-ir）
+func (p *irProgram) dump(w io.Writer) error {
+	for i, f := range p.funcs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		f.dump(w)
+	}
+	return nil
+}
+
+func (f *irFunc) dump(w io.Writer) {
+	var params []string
+	for v := f.fn.params; v != nil; v = v.next {
+		params = append(params, fmt.Sprintf("%s %s", v.ty, *v.name))
+	}
+	fmt.Fprintf(w, "func %s(%s) {\n", f.name(), strings.Join(params, ", "))
+	for _, b := range f.blocks {
+		fmt.Fprintf(w, "bb%d:\n", b.id)
+		for _, inst := range b.insts {
+			if inst.dst != 0 {
+				fmt.Fprintf(w, "\t%%%d:%s = %s\n", inst.dst, f.types[inst.dst], inst)
+			} else {
+				fmt.Fprintf(w, "\t%s\n", inst)
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
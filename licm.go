@@ -0,0 +1,143 @@
+package g9cc
+
+import "sort"
+
+// licm はループ内で値の変わらない計算をループの前（preheader）に移す。
+// 対象は副作用がなく例外も起こさない命令と、ループ内で書き換えられない
+// （ポインタ経由でも書き換えられない）ローカル変数の load。
+func licm(f *irFunc) {
+	dom := f.dominators()
+	preds := f.preds()
+
+	// 後退辺 b -> h（h が b を支配する）ごとに自然ループを集める
+	type loop struct {
+		header *irBlock
+		body   map[*irBlock]bool
+	}
+	byHeader := map[*irBlock]*loop{}
+	var loops []*loop
+	for _, b := range f.blocks {
+		for _, h := range b.succs() {
+			if !dom[b.id][h.id] {
+				continue
+			}
+			l := byHeader[h]
+			if l == nil {
+				l = &loop{header: h, body: map[*irBlock]bool{h: true}}
+				byHeader[h] = l
+				loops = append(loops, l)
+			}
+			stack := []*irBlock{b}
+			for len(stack) > 0 {
+				x := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if l.body[x] {
+					continue
+				}
+				l.body[x] = true
+				stack = append(stack, preds[x]...)
+			}
+		}
+	}
+	// 内側のループから処理し、外側のループでさらに外へ出せるようにする
+	sort.SliceStable(loops, func(i, j int) bool { return len(loops[i].body) < len(loops[j].body) })
+
+	escaped := f.escapedLocals()
+	for _, l := range loops {
+		pre := f.preheader(l.header, l.body)
+		for _, outer := range loops {
+			if outer != l && outer.body[l.header] {
+				outer.body[pre] = true
+			}
+		}
+		hoistInvariants(f, l.body, pre, escaped)
+	}
+}
+
+// preheader はループ header の直前に必ず通るブロックを返す。
+// ループ外からの先行ブロックが jmp header だけで終わる 1 つでなければ新しく作る。
+func (f *irFunc) preheader(header *irBlock, body map[*irBlock]bool) *irBlock {
+	var outside []*irBlock
+	for _, p := range f.preds()[header] {
+		if !body[p] {
+			outside = append(outside, p)
+		}
+	}
+	if len(outside) == 1 && len(outside[0].succs()) == 1 {
+		return outside[0]
+	}
+
+	pre := &irBlock{insts: []*irInst{{op: irJmp, then: header}}}
+	for _, p := range outside {
+		last := p.insts[len(p.insts)-1]
+		if last.then == header {
+			last.then = pre
+		}
+		if last.els == header {
+			last.els = pre
+		}
+	}
+	for i, b := range f.blocks {
+		if b == header {
+			f.blocks = append(f.blocks[:i], append([]*irBlock{pre}, f.blocks[i:]...)...)
+			break
+		}
+	}
+	f.renumber()
+	return pre
+}
+
+func hoistInvariants(f *irFunc, body map[*irBlock]bool, pre *irBlock, escaped map[*obj]bool) {
+	defs := f.defs()
+	defBlock := map[int]*irBlock{}
+	stored := map[*obj]bool{}
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			if inst.dst != 0 {
+				defBlock[inst.dst] = b
+			}
+			if body[b] && inst.op == irStore {
+				if d := defs[inst.args[0]]; d != nil && d.op == irLocalAddr {
+					stored[d.v] = true
+				}
+			}
+		}
+	}
+
+	invariant := func(inst *irInst) bool {
+		if inst.dst == 0 || !inst.isPure() || inst.op == irDiv {
+			return false
+		}
+		if inst.op == irLoad {
+			d := defs[inst.args[0]]
+			if d == nil || d.op != irLocalAddr || escaped[d.v] || stored[d.v] {
+				return false
+			}
+		}
+		for _, a := range inst.args {
+			if body[defBlock[a]] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.blocks {
+			if !body[b] {
+				continue
+			}
+			b.removeInsts(func(inst *irInst) bool {
+				if !invariant(inst) {
+					return true
+				}
+				last := len(pre.insts) - 1
+				pre.insts = append(pre.insts[:last], inst, pre.insts[last])
+				defBlock[inst.dst] = pre
+				changed = true
+				return false
+			})
+		}
+	}
+}
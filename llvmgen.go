@@ -0,0 +1,631 @@
+package g9cc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// -emit-llvm: 型付き AST をテキスト形式の LLVM IR にする。ポインタは不透明ポインタ（ptr）で書く。
+//
+//   - ローカル変数と引数は関数の先頭の alloca に置き、mem2reg に任せる（& を取られる変数があれば
+//     フレーム全体を 1 つの alloca にする）
+//   - ポインタと整数の加減算（sema が p + i*size にしたもの）は getelementptr に戻す
+//   - 文字列リテラルは private な定数のグローバル変数にする
+//   - 定義のない関数は最初の呼び出しの引数の型で declare する
+
+// llvmTriples は -target の名前ごとの LLVM のターゲットトリプル
+var llvmTriples = map[string]string{
+	"":              "x86_64-pc-linux-gnu",
+	"x86_64-linux":  "x86_64-pc-linux-gnu",
+	"aarch64-linux": "aarch64-unknown-linux-gnu",
+	"riscv64-linux": "riscv64-unknown-linux-gnu",
+	"wasm32-wasi":   "wasm32-unknown-wasi",
+}
+
+// llvmValue は IR の値とその型
+type llvmValue struct {
+	v, ty string
+}
+
+func (v llvmValue) String() string {
+	return v.ty + " " + v.v
+}
+
+type llvmGenerator struct {
+	w   *bufio.Writer
+	cnt int
+
+	funcs      map[string]*obj // このファイルで定義した関数
+	fn         *obj
+	tmp        int             // 関数内の一時値の番号
+	slots      map[*obj]string // ローカル変数の alloca の名前
+	terminated bool            // 今の基本ブロックが br や ret で終わっているか
+}
+
+func (g *llvmGenerator) line(format string, args ...any) {
+	fmt.Fprintf(g.w, format, args...)
+	g.w.WriteByte('\n')
+}
+
+// ins は命令を 1 行書く。直前の基本ブロックが終わっていれば、到達しないブロックを始める。
+func (g *llvmGenerator) ins(format string, args ...any) {
+	if g.terminated {
+		g.label(".Ldead%d", g.count())
+	}
+	g.line("  "+format, args...)
+}
+
+// value は結果を持つ命令を書き、その値を返す
+func (g *llvmGenerator) value(ty, format string, args ...any) llvmValue {
+	g.tmp++
+	name := fmt.Sprintf("%%.%d", g.tmp)
+	g.ins("%s = "+format, append([]any{name}, args...)...)
+	return llvmValue{name, ty}
+}
+
+func (g *llvmGenerator) label(format string, args ...any) {
+	g.line(format+":", args...)
+	g.terminated = false
+}
+
+// br は基本ブロックを終える分岐を書く（終わっていれば何もしない）
+func (g *llvmGenerator) br(format string, args ...any) {
+	if !g.terminated {
+		g.line("  br "+format, args...)
+	}
+	g.terminated = true
+}
+
+func (g *llvmGenerator) count() int {
+	g.cnt++
+	return g.cnt
+}
+
+// llvmType は型を IR の型にする
+func llvmType(t *ty) string {
+	switch t.kind {
+	case tyChar:
+		return "i8"
+	case tyPtr:
+		return "ptr"
+	case tyArray:
+		return fmt.Sprintf("[%d x %s]", t.arrayLen, llvmType(t.base))
+	}
+	return "i32"
+}
+
+// llvmGlobalName はグローバルな名前を IR の名前にする
+func llvmGlobalName(name string) string {
+	return "@" + name
+}
+
+// convert は値を型 to に変換する（整数は符号拡張か切り詰め、整数とポインタは相互に変換する）
+func (g *llvmGenerator) convert(v llvmValue, to string) llvmValue {
+	if v.ty == to {
+		return v
+	}
+	switch {
+	case v.ty == "ptr":
+		return g.value(to, "ptrtoint ptr %s to %s", v.v, to)
+	case to == "ptr":
+		return g.value(to, "inttoptr %s to ptr", v)
+	case intBits(v.ty) < intBits(to):
+		// 定数はそのまま広い型の定数にする
+		if _, err := strconv.Atoi(v.v); err == nil {
+			return llvmValue{v.v, to}
+		}
+		return g.value(to, "sext %s to %s", v, to)
+	default:
+		return g.value(to, "trunc %s to %s", v, to)
+	}
+}
+
+func intBits(ty string) int {
+	var n int
+	fmt.Sscanf(ty, "i%d", &n)
+	return n
+}
+
+// arith は整数演算の型（i64 の値があれば i64、それ以外は i32）に値をそろえる
+func (g *llvmGenerator) arith(lhs, rhs llvmValue) (llvmValue, llvmValue) {
+	ty := "i32"
+	if lhs.ty == "i64" || rhs.ty == "i64" {
+		ty = "i64"
+	}
+	return g.convert(lhs, ty), g.convert(rhs, ty)
+}
+
+// truth は値が 0（ヌルポインタ）でないかを i1 で返す
+func (g *llvmGenerator) truth(v llvmValue) llvmValue {
+	if v.ty == "ptr" {
+		return g.value("i1", "icmp ne ptr %s, null", v.v)
+	}
+	return g.value("i1", "icmp ne %s, 0", v)
+}
+
+// elemIndex は sema が p + i*size にした右辺から要素の添字を取り出す。
+// 取り出せなければ ok が false で、バイト単位のオフセットとして扱う。
+func (g *llvmGenerator) elemIndex(rhs *node, size int) (idx llvmValue, ok bool, err error) {
+	if rhs.kind == ndMul && rhs.rhs.kind == ndNum && rhs.rhs.val == size {
+		idx, err = g.genExpr(rhs.lhs)
+		return idx, true, err
+	}
+	idx, err = g.genExpr(rhs)
+	return idx, false, err
+}
+
+// genPtrArith はポインタと整数の加減算を getelementptr にする
+func (g *llvmGenerator) genPtrArith(node *node) (llvmValue, error) {
+	ptr, err := g.genExpr(node.lhs)
+	if err != nil {
+		return llvmValue{}, err
+	}
+	elem := node.ty.base
+	idx, ok, err := g.elemIndex(node.rhs, elem.size)
+	if err != nil {
+		return llvmValue{}, err
+	}
+	idx = g.convert(idx, "i64")
+	if node.kind == ndSub {
+		if n, err := strconv.Atoi(idx.v); err == nil {
+			idx.v = strconv.Itoa(-n)
+		} else {
+			idx = g.value("i64", "sub i64 0, %s", idx.v)
+		}
+	}
+	elemTy := llvmType(elem)
+	if !ok {
+		elemTy = "i8"
+	}
+	return g.value("ptr", "getelementptr %s, ptr %s, %s", elemTy, ptr.v, idx), nil
+}
+
+// addr は左辺値のアドレスを返す
+func (g *llvmGenerator) addr(node *node) (llvmValue, error) {
+	switch node.kind {
+	case ndVar:
+		if node.lvar.isLocal {
+			return llvmValue{g.slots[node.lvar], "ptr"}, nil
+		}
+		return llvmValue{llvmGlobalName(*node.lvar.name), "ptr"}, nil
+	case ndDeref:
+		v, err := g.genExpr(node.lhs)
+		if err != nil {
+			return llvmValue{}, err
+		}
+		return g.convert(v, "ptr"), nil
+	}
+
+	// 左辺値の検査は sema で済んでいる
+	return llvmValue{}, fmt.Errorf("internal error: not an lvalue: %d", node.kind)
+}
+
+// load は addr にある ty 型の値を読む（配列はその先頭のアドレスになる）
+func (g *llvmGenerator) load(addr llvmValue, t *ty) llvmValue {
+	if t.kind == tyArray {
+		return addr
+	}
+	ty := llvmType(t)
+	return g.value(ty, "load %s, ptr %s, align %d", ty, addr.v, t.align())
+}
+
+var llvmBinOps = map[nodeKind]string{ndAdd: "add", ndSub: "sub", ndMul: "mul", ndDiv: "sdiv"}
+var llvmCmps = map[nodeKind]string{ndEq: "eq", ndNe: "ne", ndLt: "slt", ndLe: "sle"}
+
+func (g *llvmGenerator) genExpr(node *node) (llvmValue, error) {
+	switch node.kind {
+	case ndNum:
+		if int64(int32(node.val)) != int64(node.val) {
+			return llvmValue{fmt.Sprint(node.val), "i64"}, nil
+		}
+		return llvmValue{fmt.Sprint(node.val), "i32"}, nil
+	case ndVar, ndDeref:
+		addr, err := g.addr(node)
+		if err != nil {
+			return llvmValue{}, err
+		}
+		return g.load(addr, node.ty), nil
+	case ndAddr:
+		return g.addr(node.lhs)
+	case ndAssign:
+		addr, err := g.addr(node.lhs)
+		if err != nil {
+			return llvmValue{}, err
+		}
+		v, err := g.genExpr(node.rhs)
+		if err != nil {
+			return llvmValue{}, err
+		}
+		v = g.convert(v, llvmType(node.lhs.ty))
+		g.ins("store %s, ptr %s, align %d", v, addr.v, node.lhs.ty.align())
+		return v, nil
+	case ndFuncall:
+		return g.genCall(node)
+	case ndAdd, ndSub:
+		if node.ty.kind == tyPtr {
+			return g.genPtrArith(node)
+		}
+	}
+
+	lhs, err := g.genExpr(node.lhs)
+	if err != nil {
+		return llvmValue{}, err
+	}
+	rhs, err := g.genExpr(node.rhs)
+	if err != nil {
+		return llvmValue{}, err
+	}
+
+	if cmp, ok := llvmCmps[node.kind]; ok {
+		if lhs.ty == "ptr" || rhs.ty == "ptr" {
+			lhs, rhs = g.convert(lhs, "ptr"), g.convert(rhs, "ptr")
+		} else {
+			lhs, rhs = g.arith(lhs, rhs)
+		}
+		c := g.value("i1", "icmp %s %s, %s", cmp, lhs, rhs.v)
+		return g.value("i32", "zext i1 %s to i32", c.v), nil
+	}
+	op, ok := llvmBinOps[node.kind]
+	if !ok {
+		return llvmValue{}, fmt.Errorf("internal error: unexpected node kind: %d", node.kind)
+	}
+	if lhs.ty == "ptr" && rhs.ty == "ptr" {
+		// ポインタどうしの差（sema が要素の大きさでの除算で包んでいる）
+		d := g.value("i64", "sub i64 %s, %s", g.convert(lhs, "i64").v, g.convert(rhs, "i64").v)
+		return g.convert(d, "i32"), nil
+	}
+	lhs, rhs = g.arith(lhs, rhs)
+	return g.value(lhs.ty, "%s %s, %s", op, lhs, rhs.v), nil
+}
+
+// callee は関数の戻り値と引数の IR の型を返す。定義のない関数は int を返し、
+// 引数は既定の実引数拡張をした型で受け取るものとする。
+func (g *llvmGenerator) callee(node *node, args []llvmValue) (ret string, params []string) {
+	fn, ok := g.funcs[node.funcname]
+	if !ok {
+		for _, a := range args {
+			params = append(params, promote(a.ty))
+		}
+		return "i32", params
+	}
+	for p := fn.params; p != nil; p = p.next {
+		params = append(params, llvmType(p.ty))
+	}
+	return llvmType(fn.ty.returnTy), params
+}
+
+// promote は既定の実引数拡張（char は int に）をした型を返す
+func promote(ty string) string {
+	if ty == "i8" {
+		return "i32"
+	}
+	return ty
+}
+
+// ext は ABI で符号拡張して渡す型なら signext 属性を返す
+func ext(ty string) string {
+	if ty == "i8" {
+		return " signext"
+	}
+	return ""
+}
+
+// retExt は戻り値の型の前に置く signext 属性を返す
+func retExt(ty string) string {
+	if ty == "i8" {
+		return "signext "
+	}
+	return ""
+}
+
+func (g *llvmGenerator) genCall(node *node) (llvmValue, error) {
+	var args []llvmValue
+	for _, arg := range node.args {
+		v, err := g.genExpr(arg)
+		if err != nil {
+			return llvmValue{}, err
+		}
+		args = append(args, v)
+	}
+	ret, params := g.callee(node, args)
+	var list []string
+	for i, a := range args {
+		ty := promote(a.ty)
+		if i < len(params) {
+			ty = params[i]
+		}
+		a = g.convert(a, ty)
+		list = append(list, fmt.Sprintf("%s%s %s", a.ty, ext(a.ty), a.v))
+	}
+	v := g.value(ret, "call %s%s (%s) %s(%s)", retExt(ret), ret, strings.Join(params, ", "), llvmGlobalName(node.funcname), strings.Join(list, ", "))
+	return g.convert(v, llvmType(node.ty)), nil
+}
+
+func (g *llvmGenerator) genStmt(node *node) error {
+	switch node.kind {
+	case ndExprStmt:
+		_, err := g.genExpr(node.lhs)
+		return err
+	case ndReturn:
+		v, err := g.genExpr(node.lhs)
+		if err != nil {
+			return err
+		}
+		v = g.convert(v, llvmType(g.fn.ty.returnTy))
+		g.ins("ret %s", v)
+		g.terminated = true
+		return nil
+	case ndIf:
+		cnt := g.count()
+		c, err := g.genExpr(node.cond)
+		if err != nil {
+			return err
+		}
+		g.br("%s, label %%.Lthen%d, label %%.Lelse%d", g.truth(c), cnt, cnt)
+		g.label(".Lthen%d", cnt)
+		if err := g.genStmt(node.then); err != nil {
+			return err
+		}
+		g.br("label %%.Lend%d", cnt)
+		g.label(".Lelse%d", cnt)
+		if node.els != nil {
+			if err := g.genStmt(node.els); err != nil {
+				return err
+			}
+		}
+		g.br("label %%.Lend%d", cnt)
+		g.label(".Lend%d", cnt)
+		return nil
+	case ndWhile:
+		return g.loop(node.lhs, node.rhs, nil)
+	case ndFor:
+		if node.init != nil {
+			if _, err := g.genExpr(node.init); err != nil {
+				return err
+			}
+		}
+		return g.loop(node.cond, node.then, node.inc)
+	case ndBlock:
+		for n := node.lhs; n != nil; n = n.next {
+			if err := g.genStmt(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("internal error: invalid statement: %d", node.kind)
+	}
+}
+
+// loop は while と for を条件・本体・後処理の基本ブロックにする
+func (g *llvmGenerator) loop(cond, body, inc *node) error {
+	cnt := g.count()
+	g.br("label %%.Lbegin%d", cnt)
+	g.label(".Lbegin%d", cnt)
+	if cond != nil {
+		c, err := g.genExpr(cond)
+		if err != nil {
+			return err
+		}
+		g.br("%s, label %%.Lbody%d, label %%.Lend%d", g.truth(c), cnt, cnt)
+	} else {
+		g.br("label %%.Lbody%d", cnt)
+	}
+	g.label(".Lbody%d", cnt)
+	if body != nil {
+		if err := g.genStmt(body); err != nil {
+			return err
+		}
+	}
+	if inc != nil {
+		if _, err := g.genExpr(inc); err != nil {
+			return err
+		}
+	}
+	g.br("label %%.Lbegin%d", cnt)
+	g.label(".Lend%d", cnt)
+	return nil
+}
+
+// linkage はグローバルな名前のリンケージを返す
+func linkage(v *obj) string {
+	switch {
+	case isStringLiteral(v):
+		return "private unnamed_addr "
+	case v.isStatic:
+		return "internal "
+	}
+	return ""
+}
+
+func (g *llvmGenerator) genFunc(fn *obj) error {
+	g.fn = fn
+	g.tmp = 0
+	g.terminated = false
+	g.slots = map[*obj]string{}
+
+	params, locals := frameVars(fn)
+	used := map[string]bool{}
+	var sig []string
+	for _, v := range append(params, locals...) {
+		name := "%" + *v.name
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("%%%s.%d", *v.name, i)
+		}
+		used[name] = true
+		g.slots[v] = name
+	}
+	for _, p := range params {
+		ty := llvmType(p.ty)
+		sig = append(sig, fmt.Sprintf("%s%s %s.arg", ty, ext(ty), g.slots[p]))
+	}
+
+	ret := llvmType(fn.ty.returnTy)
+	g.line("define %s%s%s %s(%s) {", linkage(fn), retExt(ret), ret, llvmGlobalName(*fn.name), strings.Join(sig, ", "))
+	g.label("entry")
+	vars := append(params, locals...)
+	if addressTaken(fn) {
+		// & を取られるローカル変数があれば、ポインタ演算で隣の変数に届くコードのために
+		// フレームを 1 つの alloca にし、変数の並びを x86-64 のコード生成と同じにする
+		size := frameSize(fn)
+		g.ins("%%.frame = alloca [%d x i8], align 16", size)
+		for _, v := range vars {
+			g.ins("%s = getelementptr i8, ptr %%.frame, i64 %d", g.slots[v], size-v.offset)
+		}
+	} else {
+		for _, v := range vars {
+			g.ins("%s = alloca %s, align %d", g.slots[v], llvmType(v.ty), v.ty.align())
+		}
+	}
+	for _, p := range params {
+		g.ins("store %s %s.arg, ptr %s, align %d", llvmType(p.ty), g.slots[p], g.slots[p], p.ty.align())
+	}
+
+	if err := g.genStmt(fn.body); err != nil {
+		return err
+	}
+	// 末尾まで来たら 0 を返す
+	if !g.terminated {
+		zero := "0"
+		if ret == "ptr" {
+			zero = "null"
+		}
+		g.ins("ret %s %s", ret, zero)
+	}
+	g.line("}")
+	g.line("")
+	return nil
+}
+
+// llvmConstant は初期値のバイト列から ty 型の定数を作る
+func llvmConstant(t *ty, data string) string {
+	if strings.Trim(data, "\x00") == "" {
+		if t.kind == tyPtr {
+			return "null"
+		}
+		return "zeroinitializer"
+	}
+	switch t.kind {
+	case tyArray:
+		if t.base.kind == tyChar {
+			return "c" + llvmString(data)
+		}
+		var elems []string
+		for i := range t.arrayLen {
+			size := t.base.size
+			elems = append(elems, llvmType(t.base)+" "+llvmConstant(t.base, data[i*size:(i+1)*size]))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case tyPtr:
+		return fmt.Sprintf("inttoptr (i64 %d to ptr)", int64(littleEndian(data)))
+	case tyChar:
+		return fmt.Sprint(int8(data[0]))
+	}
+	return fmt.Sprint(int32(littleEndian(data)))
+}
+
+func littleEndian(data string) uint64 {
+	var v uint64
+	for i := len(data) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(data[i])
+	}
+	return v
+}
+
+// llvmString はバイト列を IR の文字列定数の本体にする
+func llvmString(data string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c >= 0x20 && c < 0x7f && c != '"' && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "\\%02X", c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func (g *llvmGenerator) genGlobal(v *obj) {
+	ty := llvmType(v.ty)
+	name := llvmGlobalName(*v.name)
+	switch {
+	case v.isExtern:
+		g.line("%s = external global %s, align %d", name, ty, globalAlign(v.ty))
+		return
+	case v.initData == nil && !v.ty.readOnly():
+		// 仮定義は .comm と同じく common にする（static なら internal）
+		link := "common "
+		if v.isStatic {
+			link = "internal "
+		}
+		g.line("%s = %sglobal %s %s, align %d", name, link, ty, llvmConstant(v.ty, ""), globalAlign(v.ty))
+		return
+	}
+	kind := "global"
+	if v.ty.readOnly() || isStringLiteral(v) {
+		kind = "constant"
+	}
+	init := "zeroinitializer"
+	if v.initData != nil {
+		init = llvmConstant(v.ty, *v.initData)
+	}
+	g.line("%s = %s%s %s %s, align %d", name, linkage(v), kind, ty, init, globalAlign(v.ty))
+}
+
+// declarations は定義のない関数を、最初に呼ぶ箇所の引数の型で宣言する
+func (g *llvmGenerator) declarations(prog *obj) {
+	seen := map[string]bool{}
+	for fn := prog; fn != nil; fn = fn.next {
+		if !fn.isFunction || fn.body == nil {
+			continue
+		}
+		walkNodes(fn.body, func(n *node) {
+			if n.kind != ndFuncall || g.funcs[n.funcname] != nil || seen[n.funcname] {
+				return
+			}
+			seen[n.funcname] = true
+			var params []string
+			for _, arg := range n.args {
+				params = append(params, promote(llvmType(arg.ty)))
+			}
+			g.line("declare i32 %s(%s)", llvmGlobalName(n.funcname), strings.Join(params, ", "))
+		})
+	}
+}
+
+// codegenLLVM は型付き AST からテキスト形式の LLVM IR を生成する
+func codegenLLVM(prog *obj, w io.Writer, c *compilation) error {
+	g := &llvmGenerator{w: bufio.NewWriter(w), funcs: map[string]*obj{}}
+	for fn := prog; fn != nil; fn = fn.next {
+		if fn.isFunction && fn.body != nil {
+			g.funcs[*fn.name] = fn
+		}
+	}
+
+	g.line("; ModuleID = '%s'", c.filename)
+	g.line("source_filename = %s", llvmString(c.filename))
+	g.line("target triple = %q", llvmTriples[c.opts.Target])
+	g.line("")
+	for v := prog; v != nil; v = v.next {
+		if !v.isFunction {
+			g.genGlobal(v)
+		}
+	}
+	g.line("")
+	for fn := prog; fn != nil; fn = fn.next {
+		if !fn.isFunction || fn.body == nil {
+			continue
+		}
+		if err := g.genFunc(fn); err != nil {
+			return err
+		}
+	}
+	g.declarations(prog)
+	return g.w.Flush()
+}
@@ -0,0 +1,278 @@
+package g9cc
+
+import "fmt"
+
+// 型付き AST を IR に変換する。
+// ポインタ演算のスケーリングや sizeof は sema で済んでいるので、ここでは
+// アドレス計算・ロード/ストア・制御フローを命令とブロックに展開するだけ。
+
+type lowerer struct {
+	f   *irFunc
+	cur *irBlock // 命令を追加中のブロック
+}
+
+func lower(prog *obj) (*irProgram, error) {
+	p := &irProgram{prog: prog}
+	for fn := prog; fn != nil; fn = fn.next {
+		if !fn.isFunction {
+			continue
+		}
+		f, err := lowerFunc(fn)
+		if err != nil {
+			return nil, err
+		}
+		p.funcs = append(p.funcs, f)
+	}
+	return p, nil
+}
+
+func lowerFunc(fn *obj) (*irFunc, error) {
+	l := &lowerer{f: &irFunc{fn: fn}}
+	l.cur = l.f.newBlock()
+	if err := l.stmt(fn.body); err != nil {
+		return nil, err
+	}
+	// 末尾に到達しうる関数は値なしで戻る（main は 0 を返す）。
+	// ソースの文に対応しないので位置（-g の .loc、-fverbose-asm の行）は持たない。
+	if !l.cur.terminated() {
+		ret := &irInst{op: irRet}
+		if fn.returnsZero {
+			ret.args = []int{l.emitReg(irTypeOf(intType()), &irInst{op: irImm, imm: 0})}
+		}
+		l.emit(ret)
+	}
+	l.f.removeUnreachable()
+	l.f.renumber()
+	return l.f, nil
+}
+
+func (l *lowerer) emit(inst *irInst) *irInst {
+	l.cur.insts = append(l.cur.insts, inst)
+	return inst
+}
+
+// emitReg は結果を持つ命令を追加し、その仮想レジスタを返す
+func (l *lowerer) emitReg(t irType, inst *irInst) int {
+	inst.dst = l.f.newReg(t)
+	l.emit(inst)
+	return inst.dst
+}
+
+func (l *lowerer) jmp(to *irBlock, tok *token) {
+	l.emit(&irInst{op: irJmp, then: to, tok: tok})
+}
+
+func (l *lowerer) br(cond int, then, els *irBlock, tok *token) {
+	l.emit(&irInst{op: irBr, args: []int{cond}, then: then, els: els, tok: tok})
+}
+
+var irBinaryOps = map[nodeKind]irOp{
+	ndAdd: irAdd,
+	ndSub: irSub,
+	ndMul: irMul,
+	ndDiv: irDiv,
+	ndEq:  irEq,
+	ndNe:  irNe,
+	ndLt:  irLt,
+	ndLe:  irLe,
+}
+
+func (l *lowerer) load(addr int, t *ty, tok *token) int {
+	// 配列はロードせず、アドレスをそのまま値にする
+	if t.kind == tyArray {
+		return addr
+	}
+	return l.emitReg(irTypeOf(t), &irInst{op: irLoad, args: []int{addr}, size: t.size, tok: tok})
+}
+
+func (l *lowerer) expr(n *node) (int, error) {
+	switch n.kind {
+	case ndNum:
+		return l.emitReg(irTypeOf(n.ty), &irInst{op: irImm, imm: n.val, tok: n.tok}), nil
+	case ndVar:
+		addr, err := l.addr(n)
+		if err != nil {
+			return 0, err
+		}
+		return l.load(addr, n.ty, n.tok), nil
+	case ndAssign:
+		addr, err := l.addr(n.lhs)
+		if err != nil {
+			return 0, err
+		}
+		val, err := l.expr(n.rhs)
+		if err != nil {
+			return 0, err
+		}
+		l.emit(&irInst{op: irStore, args: []int{addr, val}, size: n.lhs.ty.size, tok: n.tok})
+		return val, nil
+	case ndAddr:
+		return l.addr(n.lhs)
+	case ndDeref:
+		addr, err := l.expr(n.lhs)
+		if err != nil {
+			return 0, err
+		}
+		return l.load(addr, n.ty, n.tok), nil
+	case ndFuncall:
+		var args []int
+		for _, arg := range n.args {
+			r, err := l.expr(arg)
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, r)
+		}
+		return l.emitReg(irTypeOf(n.ty), &irInst{op: irCall, args: args, sym: n.funcname, tok: n.tok}), nil
+	}
+
+	op, ok := irBinaryOps[n.kind]
+	if !ok {
+		return 0, fmt.Errorf("internal error: cannot lower expression: %s", n.kind)
+	}
+	lhs, err := l.expr(n.lhs)
+	if err != nil {
+		return 0, err
+	}
+	rhs, err := l.expr(n.rhs)
+	if err != nil {
+		return 0, err
+	}
+	return l.emitReg(irTypeOf(n.ty), &irInst{op: op, args: []int{lhs, rhs}, tok: n.tok}), nil
+}
+
+// addr は左辺値のアドレスを返す
+func (l *lowerer) addr(n *node) (int, error) {
+	switch n.kind {
+	case ndVar:
+		if n.lvar.isLocal {
+			return l.emitReg(irPtr, &irInst{op: irLocalAddr, v: n.lvar, tok: n.tok}), nil
+		}
+		return l.emitReg(irPtr, &irInst{op: irGlobalAddr, v: n.lvar, tok: n.tok}), nil
+	case ndDeref:
+		return l.expr(n.lhs)
+	}
+	return 0, fmt.Errorf("internal error: not an lvalue: %s", n.kind)
+}
+
+// cond は条件式を評価し、真なら then、偽なら els へ分岐する
+func (l *lowerer) cond(n *node, then, els *irBlock) error {
+	c, err := l.expr(n)
+	if err != nil {
+		return err
+	}
+	l.br(c, then, els, n.tok)
+	return nil
+}
+
+func (l *lowerer) stmt(n *node) error {
+	switch n.kind {
+	case ndExprStmt:
+		_, err := l.expr(n.lhs)
+		return err
+	case ndReturn:
+		val, err := l.expr(n.lhs)
+		if err != nil {
+			return err
+		}
+		l.emit(&irInst{op: irRet, args: []int{val}, tok: n.tok})
+		// return の後の文は到達しないブロックに置き、最後に取り除く
+		l.cur = l.f.newBlock()
+		return nil
+	case ndIf:
+		then, els, end := l.f.newBlock(), l.f.newBlock(), l.f.newBlock()
+		if err := l.cond(n.cond, then, els); err != nil {
+			return err
+		}
+		l.cur = then
+		if err := l.stmt(n.then); err != nil {
+			return err
+		}
+		l.jmp(end, n.tok)
+		l.cur = els
+		if n.els != nil {
+			if err := l.stmt(n.els); err != nil {
+				return err
+			}
+		}
+		l.jmp(end, n.tok)
+		l.cur = end
+		return nil
+	case ndWhile:
+		head, body, end := l.f.newBlock(), l.f.newBlock(), l.f.newBlock()
+		l.jmp(head, n.tok)
+		l.cur = head
+		if err := l.cond(n.lhs, body, end); err != nil {
+			return err
+		}
+		l.cur = body
+		if err := l.stmt(n.rhs); err != nil {
+			return err
+		}
+		l.jmp(head, n.tok)
+		l.cur = end
+		return nil
+	case ndFor:
+		if n.init != nil {
+			if _, err := l.expr(n.init); err != nil {
+				return err
+			}
+		}
+		head, body, end := l.f.newBlock(), l.f.newBlock(), l.f.newBlock()
+		l.jmp(head, n.tok)
+		l.cur = head
+		if n.cond != nil {
+			if err := l.cond(n.cond, body, end); err != nil {
+				return err
+			}
+		} else {
+			l.jmp(body, n.tok)
+		}
+		l.cur = body
+		if n.then != nil {
+			if err := l.stmt(n.then); err != nil {
+				return err
+			}
+		}
+		if n.inc != nil {
+			if _, err := l.expr(n.inc); err != nil {
+				return err
+			}
+		}
+		l.jmp(head, n.tok)
+		l.cur = end
+		return nil
+	case ndBlock:
+		for stmt := n.lhs; stmt != nil; stmt = stmt.next {
+			if err := l.stmt(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("internal error: cannot lower statement: %s", n.kind)
+}
+
+// removeUnreachable は入口から到達できないブロックを取り除く
+func (f *irFunc) removeUnreachable() {
+	reached := map[*irBlock]bool{}
+	var visit func(b *irBlock)
+	visit = func(b *irBlock) {
+		if reached[b] {
+			return
+		}
+		reached[b] = true
+		for _, s := range b.succs() {
+			visit(s)
+		}
+	}
+	visit(f.blocks[0])
+
+	blocks := f.blocks[:0]
+	for _, b := range f.blocks {
+		if reached[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	f.blocks = blocks
+}
@@ -12,7 +12,7 @@ import (
 
 func Build() error {
 	fmt.Println("Building...")
-	cmd := exec.Command("go", "build", "-o", "g9cc")
+	cmd := exec.Command("go", "build", "-o", "g9cc", "./cmd/g9cc")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -22,8 +22,26 @@ func Test() error {
 	if err := Build(); err != nil {
 		return err
 	}
-	fmt.Println("Running tests...")
-	cmd := exec.Command("bash", "test.sh")
+	// 既定のコード生成（peephole あり・なし）、IR 経由のコード生成、各最適化レベルで E2E テストを走らせる
+	for _, flags := range []string{"", "-fpeephole", "-fir-codegen", "-O1", "-O2", "-masm=att"} {
+		fmt.Printf("Running tests (G9CCFLAGS=%q)...\n", flags)
+		cmd := exec.Command("bash", "test.sh")
+		cmd.Env = append(os.Environ(), "G9CCFLAGS="+flags)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bench は既定のコード生成と IR 経由のコード生成の出力を比べる
+func Bench() error {
+	if err := Build(); err != nil {
+		return err
+	}
+	cmd := exec.Command("bash", "bench.sh")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
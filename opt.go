@@ -0,0 +1,244 @@
+package g9cc
+
+import (
+	"fmt"
+	"io"
+)
+
+// IR の最適化パス（-O1, -O2）。
+// lower.go の出力では仮想レジスタはどれも一度しか定義されず、定義は使用を支配する。
+// どのパスもこの性質を保つ（命令を消すときは使用側を別の仮想レジスタに置き換える）。
+
+type irPass struct {
+	name       string
+	run        func(f *irFunc)
+	runProgram func(p *irProgram) // 関数をまたぐパス（run の代わりに使う）
+}
+
+var (
+	passConstProp = irPass{name: "constprop", run: constProp}
+	passCSE       = irPass{name: "cse", run: cse}
+	passDCE       = irPass{name: "dce", run: dce}
+	passStrength  = irPass{name: "strength", run: strengthReduce}
+	passLICM      = irPass{name: "licm", run: licm}
+	passInline    = irPass{name: "inline", runProgram: inlineCalls}
+	passTailCall  = irPass{name: "tailcall", run: tailCalls}
+)
+
+// optPipelines は最適化レベルごとに走らせるパスの列
+var optPipelines = map[int][]irPass{
+	1: {passConstProp, passCSE, passConstProp, passStrength, passDCE},
+	2: {passInline, passConstProp, passCSE, passConstProp, passLICM, passCSE, passStrength, passDCE, passTailCall},
+}
+
+// optimize は IR に level のパスを順に適用する。
+// dump が nil でなければ、変換前と各パスの後の IR をそこに書く。
+func optimize(p *irProgram, level int, dump io.Writer) error {
+	passes, ok := optPipelines[level]
+	if level != 0 && !ok {
+		return fmt.Errorf("invalid optimization level: %d", level)
+	}
+	if dump != nil {
+		fmt.Fprintf(dump, "*** IR dump after lower ***\n")
+		p.dump(dump)
+	}
+	for _, pass := range passes {
+		if pass.runProgram != nil {
+			pass.runProgram(p)
+		}
+		for _, f := range p.funcs {
+			if pass.run != nil {
+				pass.run(f)
+			}
+			f.renumber()
+		}
+		if dump != nil {
+			fmt.Fprintf(dump, "*** IR dump after %s ***\n", pass.name)
+			p.dump(dump)
+		}
+	}
+	return nil
+}
+
+// isPure は副作用がなく、結果を使わなければ消してよい命令かどうかを返す
+func (inst *irInst) isPure() bool {
+	switch inst.op {
+	case irImm, irLocalAddr, irGlobalAddr, irLoad, irMov,
+		irAdd, irSub, irMul, irDiv, irEq, irNe, irLt, irLe, irShl, irLea:
+		return true
+	}
+	return false
+}
+
+// replaceUses は仮想レジスタ from の使用をすべて to に置き換える
+func (f *irFunc) replaceUses(from, to int) {
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			for i, a := range inst.args {
+				if a == from {
+					inst.args[i] = to
+				}
+			}
+		}
+	}
+}
+
+// useCounts は仮想レジスタごとの使用回数を返す
+func (f *irFunc) useCounts() []int {
+	n := make([]int, len(f.types))
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			for _, a := range inst.args {
+				n[a]++
+			}
+		}
+	}
+	return n
+}
+
+// defs は仮想レジスタごとの定義命令を返す
+func (f *irFunc) defs() []*irInst {
+	d := make([]*irInst, len(f.types))
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			if inst.dst != 0 {
+				d[inst.dst] = inst
+			}
+		}
+	}
+	return d
+}
+
+// constOf は r が定数なら値を返す
+func constOf(defs []*irInst, r int) (int, bool) {
+	if d := defs[r]; d != nil && d.op == irImm {
+		return d.imm, true
+	}
+	return 0, false
+}
+
+// removeInsts は keep が偽を返す命令をブロックから取り除く
+func (b *irBlock) removeInsts(keep func(inst *irInst) bool) bool {
+	insts := b.insts[:0]
+	for _, inst := range b.insts {
+		if keep(inst) {
+			insts = append(insts, inst)
+		}
+	}
+	changed := len(insts) != len(b.insts)
+	b.insts = insts
+	return changed
+}
+
+// escapedLocals はアドレスを load/store のアドレス以外に使うローカル変数を返す。
+// それ以外のローカル変数はポインタ経由で読み書きされることがない。
+func (f *irFunc) escapedLocals() map[*obj]bool {
+	defs := f.defs()
+	escaped := map[*obj]bool{}
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			for i, a := range inst.args {
+				d := defs[a]
+				if d == nil || d.op != irLocalAddr {
+					continue
+				}
+				if (inst.op == irLoad || inst.op == irStore) && i == 0 {
+					continue
+				}
+				escaped[d.v] = true
+			}
+		}
+	}
+	// スカラーのローカル変数のアドレスが漏れていれば、そこからのポインタ演算で
+	// 隣の変数に届くこともある（test.sh の *(&x-1) など）ので、すべて漏れたとみなす
+	all := false
+	for v := range escaped {
+		if v.ty.kind != tyArray {
+			all = true
+		}
+	}
+	for v := f.fn.locals; v != nil; v = v.next {
+		if all || v.ty.kind == tyArray {
+			escaped[v] = true
+		}
+	}
+	return escaped
+}
+
+// preds はブロックごとの先行ブロックを返す
+func (f *irFunc) preds() map[*irBlock][]*irBlock {
+	p := map[*irBlock][]*irBlock{}
+	for _, b := range f.blocks {
+		for _, s := range b.succs() {
+			p[s] = append(p[s], b)
+		}
+	}
+	return p
+}
+
+// dominators はブロックごとにそれを支配するブロックの集合を返す（添字は renumber 後の id）
+func (f *irFunc) dominators() []map[int]bool {
+	f.renumber()
+	preds := f.preds()
+	dom := make([]map[int]bool, len(f.blocks))
+	all := map[int]bool{}
+	for _, b := range f.blocks {
+		all[b.id] = true
+	}
+	for _, b := range f.blocks {
+		if b.id == 0 {
+			dom[0] = map[int]bool{0: true}
+			continue
+		}
+		dom[b.id] = map[int]bool{}
+		for id := range all {
+			dom[b.id][id] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.blocks[1:] {
+			next := map[int]bool{}
+			for i, p := range preds[b] {
+				if i == 0 {
+					for id := range dom[p.id] {
+						next[id] = true
+					}
+					continue
+				}
+				for id := range next {
+					if !dom[p.id][id] {
+						delete(next, id)
+					}
+				}
+			}
+			next[b.id] = true
+			if len(next) != len(dom[b.id]) {
+				dom[b.id] = next
+				changed = true
+			}
+		}
+	}
+	return dom
+}
+
+// domTree は支配木の子を返す（直接の支配ブロックは自分以外の支配ブロックのうち最も深いもの）
+func (f *irFunc) domTree() map[*irBlock][]*irBlock {
+	dom := f.dominators()
+	children := map[*irBlock][]*irBlock{}
+	for _, b := range f.blocks[1:] {
+		var idom *irBlock
+		for _, d := range f.blocks {
+			if d == b || !dom[b.id][d.id] {
+				continue
+			}
+			if idom == nil || len(dom[d.id]) > len(dom[idom.id]) {
+				idom = d
+			}
+		}
+		if idom != nil {
+			children[idom] = append(children[idom], b)
+		}
+	}
+	return children
+}
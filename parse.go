@@ -1,4 +1,4 @@
-package main
+package g9cc
 
 import (
 	"fmt"
@@ -11,6 +11,8 @@ type parser struct {
 	input      string
 	globals    *obj
 	strSeq     int
+	diags      *diagSink // 回復したエラーの記録先
+	maxArgs    int       // ターゲットで渡せる引数の数
 }
 
 type nodeKind int
@@ -39,6 +41,37 @@ const (
 	ndNum
 )
 
+var nodeKindNames = [...]string{
+	ndAdd:      "add",
+	ndSub:      "sub",
+	ndMul:      "mul",
+	ndDiv:      "div",
+	ndEq:       "eq",
+	ndNe:       "ne",
+	ndLt:       "lt",
+	ndLe:       "le",
+	ndAssign:   "assign",
+	ndExprStmt: "expr-stmt",
+	ndVar:      "var",
+	ndReturn:   "return",
+	ndIf:       "if",
+	ndWhile:    "while",
+	ndFor:      "for",
+	ndBlock:    "block",
+	ndFuncall:  "funcall",
+	ndAddr:     "addr",
+	ndDeref:    "deref",
+	ndSizeof:   "sizeof",
+	ndNum:      "num",
+}
+
+func (k nodeKind) String() string {
+	if int(k) < len(nodeKindNames) {
+		return nodeKindNames[k]
+	}
+	return fmt.Sprintf("nodeKind(%d)", int(k))
+}
+
 type node struct {
 	kind     nodeKind // nodeの種類
 	next     *node    // 次のnodeのアドレス
@@ -54,6 +87,9 @@ type node struct {
 	funcname string   // 関数名
 	args     []*node  // 関数引数
 	ty       *ty      // ポインタを表す型
+	tok      *token   // 代表トークン（エラー位置の表示に使用）
+	paren    bool     // 括弧で囲まれていた式
+	isInit   bool     // 宣言の初期化子から作った代入（const の変数にも書ける）
 }
 
 type obj struct {
@@ -64,11 +100,16 @@ type obj struct {
 	offset     int     // local variable
 	isFunction bool    // global variable or function
 	initData   *string
+	tok        *token // 宣言された位置
+	isStatic   bool   // static（ファイルの外に見せない）
+	isExtern   bool   // extern の宣言（定義は他のファイルにある）
 	// function
-	params    *obj
-	body      *node
-	locals    *obj
-	stackSize int
+	isInline    bool // inline 指定（-O2 のインライン展開で大きさの制限を緩める）
+	returnsZero bool // 末尾に到達しうる main（C99 に従いコード生成が 0 を返す）
+	params      *obj
+	body        *node
+	locals      *obj
+	stackSize   int
 }
 
 func alignTo(n, align int) int {
@@ -84,11 +125,17 @@ func stackAllocSize(t *ty) int {
 	}
 }
 
-func getNum(tok *token) (int, error) {
-	if tok.kind != tkNum {
-		return 0, fmt.Errorf("expected a number")
+// constExpr は整数定数式を読み、型付けしてその値を返す
+func (p *parser) constExpr() (int, error) {
+	node, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	c := &checker{input: p.input, diags: p.diags}
+	if err := c.addType(node); err != nil {
+		return 0, err
 	}
-	return tok.val, nil
+	return c.eval(node)
 }
 
 func (p *parser) declareLocal(tok *token, ty *ty) (*obj, error) {
@@ -103,21 +150,47 @@ func (p *parser) declareLocal(tok *token, ty *ty) (*obj, error) {
 		offset:  p.nextOffset,
 		ty:      ty,
 		isLocal: true,
+		tok:     tok,
 	}
 	p.locals = lvar
 	return lvar, nil
 }
 
-func newNode(kind nodeKind, lhs *node, rhs *node) *node {
-	node := &node{kind: kind, lhs: lhs, rhs: rhs}
+func newNode(kind nodeKind, lhs *node, rhs *node, tok *token) *node {
+	node := &node{kind: kind, lhs: lhs, rhs: rhs, tok: tok}
 	return node
 }
 
-func newNodeNum(val int) *node {
-	node := &node{kind: ndNum, val: val}
+func newNodeNum(val int, tok *token) *node {
+	node := &node{kind: ndNum, val: val, tok: tok}
 	return node
 }
 
+// forEachChild は n の子ノードそれぞれに f を呼ぶ。
+// ブロックの文は next でつながった順に渡す。
+func forEachChild(n *node, f func(*node)) {
+	if n.kind == ndBlock {
+		for stmt := n.lhs; stmt != nil; stmt = stmt.next {
+			f(stmt)
+		}
+		return
+	}
+	for _, child := range []*node{n.lhs, n.rhs, n.cond, n.then, n.els, n.init, n.inc} {
+		if child != nil {
+			f(child)
+		}
+	}
+	for _, arg := range n.args {
+		f(arg)
+	}
+}
+
+// walkNodes は n とその下のノードすべてに f を呼ぶ
+func walkNodes(n *node, f func(*node)) {
+	f(n)
+	forEachChild(n, func(c *node) { walkNodes(c, f) })
+}
+
 func newFunc(name string, params *obj, body *node, next *obj) *obj {
 	funct := &obj{
 		name:       &name,
@@ -211,9 +284,36 @@ func reverseObjList(head *obj) *obj {
 	return prev
 }
 
-// funcdef = declspec ident "(" ( declspec ident ("," declspec ident)*)? ")" stmt
+// storage はトップレベルの宣言の記憶域クラスと inline 指定
+type storage struct {
+	isStatic bool
+	isExtern bool
+	isInline bool
+}
+
+// storage = ("static" | "extern" | "inline")*
+func (p *parser) storage() storage {
+	var s storage
+	for {
+		switch p.tok.kind {
+		case tkStatic:
+			s.isStatic = true
+		case tkExtern:
+			s.isExtern = true
+		case tkInline:
+			s.isInline = true
+		default:
+			return s
+		}
+		p.tok = p.tok.next
+	}
+}
+
+// funcdef = storage declspec ident "(" ( declspec ident ("," declspec ident)*)? ")" stmt
 func (p *parser) funcdef() (*obj, error) {
-	if _, err := p.declspec(); err != nil {
+	st := p.storage()
+	retTy, err := p.declspec()
+	if err != nil {
 		return nil, err
 	}
 
@@ -221,6 +321,10 @@ func (p *parser) funcdef() (*obj, error) {
 	p.nextOffset = 0
 	if p.tok.kind == tkIdent {
 		funct := newFunc(p.tok.str, nil, nil, nil)
+		funct.tok = p.tok
+		funct.ty = funcType(retTy)
+		funct.isInline = st.isInline
+		funct.isStatic = st.isStatic
 		p.tok = p.tok.next
 		if err := p.expect("("); err != nil {
 			return nil, err
@@ -244,8 +348,8 @@ func (p *parser) funcdef() (*obj, error) {
 					return nil, err
 				}
 
-				if nparams >= len(argregs64) {
-					return nil, errorAt(p.input, p.tok.pos, fmt.Sprintf("too many parameters: max %d", len(argregs64)))
+				if nparams >= p.maxArgs {
+					return nil, errorAt(p.input, p.tok.pos, fmt.Sprintf("too many parameters: max %d", p.maxArgs))
 				}
 				nparams++
 
@@ -269,6 +373,7 @@ func (p *parser) funcdef() (*obj, error) {
 			return nil, err
 		}
 		funct.body = body
+		funct.locals = p.locals
 		return funct, nil
 	}
 	return nil, errorAt(p.input, p.tok.pos, "unexpected token")
@@ -283,9 +388,10 @@ func (p *parser) funcdef() (*obj, error) {
 //	| "{" stmt* "}"
 //	| ident "(" (ident ",")? ")" "{" stmt "}"
 func (p *parser) stmt() (*node, error) {
+	tok := p.tok
 	switch p.tok.kind {
 	case tkIf:
-		node := newNode(ndIf, nil, nil)
+		node := newNode(ndIf, nil, nil, tok)
 		p.tok = p.tok.next
 
 		if err := p.expect("("); err != nil {
@@ -333,7 +439,7 @@ func (p *parser) stmt() (*node, error) {
 		if err != nil {
 			return nil, err
 		}
-		node := newNode(ndWhile, lhs, rhs)
+		node := newNode(ndWhile, lhs, rhs, tok)
 		return node, nil
 	case tkReturn:
 		p.tok = p.tok.next
@@ -341,7 +447,7 @@ func (p *parser) stmt() (*node, error) {
 		if err != nil {
 			return nil, err
 		}
-		node = newNode(ndReturn, node, nil)
+		node = newNode(ndReturn, node, nil, tok)
 
 		if err := p.expect(";"); err != nil {
 			return nil, err
@@ -349,7 +455,7 @@ func (p *parser) stmt() (*node, error) {
 		return node, nil
 	case tkFor:
 		p.tok = p.tok.next
-		node := newNode(ndFor, nil, nil)
+		node := newNode(ndFor, nil, nil, tok)
 		if err := p.expect("("); err != nil {
 			return nil, err
 		}
@@ -397,10 +503,15 @@ func (p *parser) stmt() (*node, error) {
 		if p.consume("{") {
 			head := new(node)
 			cur := head
-			for p.tok.str != "}" {
+			for p.tok.str != "}" && p.tok.kind != tkEOF {
 				next, err := p.stmt()
 				if err != nil {
-					return nil, err
+					// エラーを記録して次の文から解析を続ける
+					if err := p.diags.report(err); err != nil {
+						return nil, err
+					}
+					p.syncStmt()
+					continue
 				}
 				cur.next = next
 				cur = cur.next
@@ -408,29 +519,46 @@ func (p *parser) stmt() (*node, error) {
 			if err := p.expect("}"); err != nil {
 				return nil, err
 			}
-			node := newNode(ndBlock, head.next, nil)
+			node := newNode(ndBlock, head.next, nil, tok)
 			return node, nil
 		}
-	case tkInt, tkChar:
+	case tkInt, tkChar, tkConst:
 		return p.declaration()
 	}
 	return p.exprStmt()
 }
 
-// declspec = "char" | "int"
+// declspec = "const"* ("char" | "int") "const"*
 func (p *parser) declspec() (*ty, error) {
-	if p.tok.kind == tkChar {
-		p.tok = p.tok.next
-		return &ty{kind: tyChar, size: 1}, nil
+	isConst := p.consumeConst()
+	var t *ty
+	switch p.tok.kind {
+	case tkChar:
+		t = &ty{kind: tyChar, size: 1}
+	case tkInt:
+		t = &ty{kind: tyInt, size: 4}
+	default:
+		return nil, errorAt(p.input, p.tok.pos, "expected type specifier 'int'")
 	}
-	if p.tok.kind == tkInt {
+	p.tok = p.tok.next
+	if p.consumeConst() {
+		isConst = true
+	}
+	t.isConst = isConst
+	return t, nil
+}
+
+// consumeConst は続く "const" を読み飛ばし、1 つでもあったかどうかを返す
+func (p *parser) consumeConst() bool {
+	found := false
+	for p.tok.kind == tkConst {
 		p.tok = p.tok.next
-		return &ty{kind: tyInt, size: 4}, nil
+		found = true
 	}
-	return nil, errorAt(p.input, p.tok.pos, "expected type specifier 'int'")
+	return found
 }
 
-// type-suffix = "(" ( declspec ident ("," declspec ident)*)? ")" | "[" num "]" type-suffix | ε
+// type-suffix = "(" ( declspec ident ("," declspec ident)*)? ")" | "[" const-expr "]" type-suffix | ε
 func (p *parser) typeSuffix(ty *ty) (*ty, error) {
 	if p.consume("(") {
 		if !p.consume(")") {
@@ -455,11 +583,14 @@ func (p *parser) typeSuffix(ty *ty) (*ty, error) {
 	}
 
 	if p.consume("[") {
-		sz, err := getNum(p.tok)
+		tok := p.tok
+		sz, err := p.constExpr()
 		if err != nil {
 			return nil, err
 		}
-		p.tok = p.tok.next
+		if sz < 0 {
+			return nil, errorAt(p.input, tok.pos, fmt.Sprintf("size of array is negative: %d", sz))
+		}
 		if err := p.expect("]"); err != nil {
 			return nil, err
 		}
@@ -499,6 +630,7 @@ func (p *parser) declarator(ty *ty) (*ty, *token, error) {
 
 // declaration = declspec (declarator ("=" expr)? ("," declarator ("=" expr)?)*)? ";"
 func (p *parser) declaration() (*node, error) {
+	start := p.tok
 	basety, err := p.declspec()
 	if err != nil {
 		return nil, err
@@ -507,7 +639,7 @@ func (p *parser) declaration() (*node, error) {
 	head := new(node)
 	cur := head
 	if p.consume(";") {
-		return newNode(ndBlock, head.next, nil), nil
+		return newNode(ndBlock, head.next, nil, start), nil
 	}
 	for {
 		ty, tok, err := p.declarator(basety)
@@ -520,17 +652,19 @@ func (p *parser) declaration() (*node, error) {
 			return nil, err
 		}
 
+		eq := p.tok
 		if p.consume("=") {
 			rhs, err := p.expr()
 			if err != nil {
 				return nil, err
 			}
 
-			lhs := newNode(ndVar, nil, nil)
+			lhs := newNode(ndVar, nil, nil, tok)
 			lhs.lvar = lvar
 
-			assign := newNode(ndAssign, lhs, rhs)
-			stmt := newNode(ndExprStmt, assign, nil)
+			assign := newNode(ndAssign, lhs, rhs, eq)
+			assign.isInit = true
+			stmt := newNode(ndExprStmt, assign, nil, eq)
 
 			cur.next = stmt
 			cur = cur.next
@@ -543,20 +677,21 @@ func (p *parser) declaration() (*node, error) {
 	if err := p.expect(";"); err != nil {
 		return nil, err
 	}
-	return newNode(ndBlock, head.next, nil), nil
+	return newNode(ndBlock, head.next, nil, start), nil
 }
 
 // exprStmt = expr? ";"
 func (p *parser) exprStmt() (*node, error) {
+	tok := p.tok
 	if p.consume(";") {
-		return newNode(ndBlock, nil, nil), nil
+		return newNode(ndBlock, nil, nil, tok), nil
 	}
 
 	node, err := p.expr()
 	if err != nil {
 		return nil, err
 	}
-	node = newNode(ndExprStmt, node, nil)
+	node = newNode(ndExprStmt, node, nil, tok)
 
 	if err := p.expect(";"); err != nil {
 		return nil, err
@@ -576,12 +711,13 @@ func (p *parser) assign() (*node, error) {
 		return nil, err
 	}
 	for {
+		tok := p.tok
 		if p.consume("=") {
 			rhs, err := p.assign()
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndAssign, node, rhs)
+			node = newNode(ndAssign, node, rhs, tok)
 			continue
 		}
 		return node, nil
@@ -596,12 +732,13 @@ func (p *parser) equality() (*node, error) {
 	}
 
 	for {
+		tok := p.tok
 		if p.consume("==") {
 			rhs, err := p.relational()
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndEq, node, rhs)
+			node = newNode(ndEq, node, rhs, tok)
 			continue
 		}
 		if p.consume("!=") {
@@ -609,7 +746,7 @@ func (p *parser) equality() (*node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndNe, node, rhs)
+			node = newNode(ndNe, node, rhs, tok)
 			continue
 		}
 		return node, nil
@@ -624,12 +761,13 @@ func (p *parser) relational() (*node, error) {
 	}
 
 	for {
+		tok := p.tok
 		if p.consume("<") {
 			rhs, err := p.add()
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndLt, node, rhs)
+			node = newNode(ndLt, node, rhs, tok)
 			continue
 		}
 		if p.consume("<=") {
@@ -637,7 +775,7 @@ func (p *parser) relational() (*node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndLe, node, rhs)
+			node = newNode(ndLe, node, rhs, tok)
 			continue
 		}
 		if p.consume(">") {
@@ -645,7 +783,7 @@ func (p *parser) relational() (*node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndLt, lhs, node)
+			node = newNode(ndLt, lhs, node, tok)
 			continue
 		}
 		if p.consume(">=") {
@@ -653,7 +791,7 @@ func (p *parser) relational() (*node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndLe, lhs, node)
+			node = newNode(ndLe, lhs, node, tok)
 			continue
 		}
 		return node, nil
@@ -668,12 +806,13 @@ func (p *parser) add() (*node, error) {
 	}
 
 	for {
+		tok := p.tok
 		if p.consume("+") {
 			rhs, err := p.mul()
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndAdd, node, rhs)
+			node = newNode(ndAdd, node, rhs, tok)
 			continue
 		}
 		if p.consume("-") {
@@ -681,7 +820,7 @@ func (p *parser) add() (*node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndSub, node, rhs)
+			node = newNode(ndSub, node, rhs, tok)
 			continue
 		}
 		return node, nil
@@ -696,12 +835,13 @@ func (p *parser) mul() (*node, error) {
 	}
 
 	for {
+		tok := p.tok
 		if p.consume("*") {
 			rhs, err := p.unary()
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndMul, node, rhs)
+			node = newNode(ndMul, node, rhs, tok)
 			continue
 		}
 		if p.consume("/") {
@@ -709,7 +849,7 @@ func (p *parser) mul() (*node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node = newNode(ndDiv, node, rhs)
+			node = newNode(ndDiv, node, rhs, tok)
 			continue
 		}
 		return node, nil
@@ -718,6 +858,7 @@ func (p *parser) mul() (*node, error) {
 
 // unary = ("+" | "-")? unary() | "*" unary | "&" unary | "sizeof" unary | postfix
 func (p *parser) unary() (*node, error) {
+	tok := p.tok
 	if p.consume("+") {
 		return p.unary()
 	}
@@ -727,7 +868,7 @@ func (p *parser) unary() (*node, error) {
 		if err != nil {
 			return nil, err
 		}
-		return newNode(ndSub, newNodeNum(0), prim), nil
+		return newNode(ndSub, newNodeNum(0, tok), prim, tok), nil
 	}
 
 	if p.consume("*") {
@@ -735,7 +876,7 @@ func (p *parser) unary() (*node, error) {
 		if err != nil {
 			return nil, err
 		}
-		node = newNode(ndDeref, node, nil)
+		node = newNode(ndDeref, node, nil, tok)
 		return node, nil
 	}
 
@@ -744,7 +885,7 @@ func (p *parser) unary() (*node, error) {
 		if err != nil {
 			return nil, err
 		}
-		node = newNode(ndAddr, node, nil)
+		node = newNode(ndAddr, node, nil, tok)
 		return node, nil
 	}
 
@@ -754,7 +895,7 @@ func (p *parser) unary() (*node, error) {
 		if err != nil {
 			return nil, err
 		}
-		node := newNode(ndSizeof, lhs, nil)
+		node := newNode(ndSizeof, lhs, nil, tok)
 		return node, nil
 	}
 
@@ -769,6 +910,7 @@ func (p *parser) postfix() (*node, error) {
 	}
 
 	for {
+		tok := p.tok
 		if p.consume("[") {
 			rhs, err := p.expr()
 			if err != nil {
@@ -779,8 +921,8 @@ func (p *parser) postfix() (*node, error) {
 				return nil, err
 			}
 			// x[y] => *(x + y)
-			add := newNode(ndAdd, node, rhs)
-			node = newNode(ndDeref, add, nil)
+			add := newNode(ndAdd, node, rhs, tok)
+			node = newNode(ndDeref, add, nil, tok)
 			continue
 		}
 		return node, nil
@@ -797,6 +939,7 @@ func (p *parser) primary() (*node, error) {
 		if err := p.expect(")"); err != nil {
 			return nil, err
 		}
+		node.paren = true
 		return node, nil
 	}
 
@@ -805,7 +948,7 @@ func (p *parser) primary() (*node, error) {
 		name := tok.str
 		p.tok = p.tok.next
 		if p.consume("(") {
-			node := newNode(ndFuncall, nil, nil)
+			node := newNode(ndFuncall, nil, nil, tok)
 			if !p.consume(")") {
 				arg, err := p.assign()
 				if err != nil {
@@ -834,7 +977,7 @@ func (p *parser) primary() (*node, error) {
 				return nil, errorAt(p.input, tok.pos, fmt.Sprintf("undefined variable: %s", name))
 			}
 		}
-		node := newNode(ndVar, nil, nil)
+		node := newNode(ndVar, nil, nil, tok)
 		node.lvar = lvar
 		return node, nil
 	}
@@ -843,19 +986,22 @@ func (p *parser) primary() (*node, error) {
 		tok := p.tok
 		p.tok = p.tok.next
 		lvar := p.newAnonStringLiteral(tok.str)
-		node := newNode(ndVar, nil, nil)
+		node := newNode(ndVar, nil, nil, tok)
 		node.lvar = lvar
 		return node, nil
 	}
 
+	tok := p.tok
 	num, err := p.expectNumber()
 	if err != nil {
 		return nil, err
 	}
-	return newNodeNum(num), nil
+	return newNodeNum(num, tok), nil
 }
 
+// global-variable = storage declspec declarator ("=" const-expr)? ("," declarator ("=" const-expr)?)* ";"
 func (p *parser) globalVariable() error {
+	st := p.storage()
 	basety, err := p.declspec()
 	if err != nil {
 		return err
@@ -865,7 +1011,18 @@ func (p *parser) globalVariable() error {
 		if err != nil {
 			return err
 		}
-		p.newGVar(tok.str, ty)
+		v := p.newGVar(tok.str, ty)
+		v.tok = tok
+		v.isStatic = st.isStatic
+		v.isExtern = st.isExtern
+
+		if p.consume("=") {
+			// 初期値があれば extern でも定義になる
+			v.isExtern = false
+			if err := p.globalInit(v); err != nil {
+				return err
+			}
+		}
 
 		if !p.consume(",") {
 			break
@@ -874,8 +1031,29 @@ func (p *parser) globalVariable() error {
 	return p.expect(";")
 }
 
+// globalInit はグローバル変数の初期化子（整数定数式）を読み、初期化データにする
+func (p *parser) globalInit(v *obj) error {
+	tok := p.tok
+	if v.ty.kind != tyInt && v.ty.kind != tyChar && v.ty.kind != tyPtr {
+		return errorAt(p.input, tok.pos, fmt.Sprintf("initializer for '%s' is not supported", v.ty))
+	}
+	val, err := p.constExpr()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, v.ty.size)
+	for i := range data {
+		data[i] = byte(val >> (8 * i))
+	}
+	init := string(data)
+	v.initData = &init
+	return nil
+}
+
 func isFunction(tok *token) bool {
-	p := &parser{tok: tok}
+	// 先読みなので診断は捨てる
+	p := &parser{tok: tok, diags: &diagSink{}}
+	p.storage()
 	basety, err := p.declspec()
 	if err != nil {
 		return false
@@ -888,6 +1066,63 @@ func isFunction(tok *token) bool {
 
 }
 
+// syncStmt はエラーの後、次の文の先頭まで読み飛ばす。
+// 同じ深さの ";" の次、または入れ子のブロックを閉じる "}" の次で止まり、
+// 外側のブロックを閉じる "}" は読まずに残す。
+func (p *parser) syncStmt() {
+	depth := 0
+	for p.tok.kind != tkEOF {
+		if p.tok.kind == tkPunct {
+			switch p.tok.str {
+			case "{":
+				depth++
+			case "}":
+				if depth == 0 {
+					return
+				}
+				depth--
+				if depth == 0 {
+					p.tok = p.tok.next
+					return
+				}
+			case ";":
+				if depth == 0 {
+					p.tok = p.tok.next
+					return
+				}
+			}
+		}
+		p.tok = p.tok.next
+	}
+}
+
+// syncTop はエラーの後、次のトップレベル宣言の先頭まで読み飛ばす
+func (p *parser) syncTop() {
+	depth := 0
+	for p.tok.kind != tkEOF {
+		if p.tok.kind == tkPunct {
+			switch p.tok.str {
+			case "{":
+				depth++
+			case "}":
+				if depth > 0 {
+					depth--
+				}
+				if depth == 0 {
+					p.tok = p.tok.next
+					return
+				}
+			case ";":
+				if depth == 0 {
+					p.tok = p.tok.next
+					return
+				}
+			}
+		}
+		p.tok = p.tok.next
+	}
+}
+
 func (p *parser) parse() (*obj, error) {
 	head := new(obj)
 	cur := head
@@ -896,7 +1131,11 @@ func (p *parser) parse() (*obj, error) {
 		if isFunction(p.tok) {
 			fn, err := p.funcdef()
 			if err != nil {
-				return nil, err
+				if err := p.diags.report(err); err != nil {
+					return nil, err
+				}
+				p.syncTop()
+				continue
 			}
 			cur.next = fn
 			cur = cur.next
@@ -904,7 +1143,10 @@ func (p *parser) parse() (*obj, error) {
 		}
 
 		if err := p.globalVariable(); err != nil {
-			return nil, err
+			if err := p.diags.report(err); err != nil {
+				return nil, err
+			}
+			p.syncTop()
 		}
 	}
 
@@ -0,0 +1,366 @@
+package g9cc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// 出力直前の命令列に対する peephole 最適化。
+// 各規則は命令列の位置 i から始まる短い並びを同じ意味のより短い並びに書き換える。
+// レジスタの生死は基本ブロック内だけで調べ、ラベル・ジャンプに出会ったら生きているとみなす。
+
+type peepholeRule struct {
+	name  string
+	apply func(p *peepholer, i int) bool
+}
+
+// peepholeRules は適用する規則（名前は -fpeephole-stats の出力に使う）
+var peepholeRules = []peepholeRule{
+	{"push-pop", (*peepholer).pushPop},
+	{"frame-addr", (*peepholer).frameAddr},
+	{"fold-addr", (*peepholer).foldAddr},
+	{"fold-imm", (*peepholer).foldImm},
+	{"self-mov", (*peepholer).selfMov},
+	{"dead-mov", (*peepholer).deadMov},
+}
+
+type peepholer struct {
+	insts []*asmInst
+}
+
+// peephole は変化がなくなるまで規則を適用する。stats には規則ごとに消した命令の数を足す。
+func peephole(insts []*asmInst, stats map[string]int) []*asmInst {
+	p := &peepholer{insts: insts}
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(p.insts); i++ {
+			for _, r := range peepholeRules {
+				if i >= len(p.insts) {
+					break
+				}
+				before := len(p.insts)
+				if r.apply(p, i) {
+					stats[r.name] += before - len(p.insts)
+					changed = true
+				}
+			}
+		}
+	}
+	return p.insts
+}
+
+// writePeepholeStats は規則ごとに消した命令の数を書く
+func writePeepholeStats(w io.Writer, stats map[string]int) {
+	total := 0
+	for _, r := range peepholeRules {
+		fmt.Fprintf(w, "peephole: %-10s %5d instructions removed\n", r.name, stats[r.name])
+		total += stats[r.name]
+	}
+	fmt.Fprintf(w, "peephole: %-10s %5d instructions removed\n", "total", total)
+}
+
+func (p *peepholer) remove(i int) {
+	p.insts = slices.Delete(p.insts, i, i+1)
+}
+
+func (p *peepholer) insn(i int, op string) bool {
+	in := p.insts[i]
+	return in.kind == asmInsn && in.op == op
+}
+
+var callerSavedAll = []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"}
+
+// effects は命令が読むレジスタと書くレジスタ（64 ビットの名前）を返す。
+// barrier はその命令を越えて並びを調べられない（制御が移る、スタックを使う）ことを表す。
+func (in *asmInst) effects() (reads, writes []string, barrier bool) {
+	if in.meta {
+		return nil, nil, false
+	}
+	if in.kind != asmInsn {
+		return nil, nil, true
+	}
+	// 書き込み先。8/16 ビットのレジスタへの書き込みは残りのビットを保つので読み込みでもある
+	dest := func(o asmOperand) {
+		if o.kind != opdReg {
+			reads = append(reads, o.regs()...)
+			return
+		}
+		if regSizes[o.reg] < 4 {
+			reads = append(reads, reg64(o.reg))
+		}
+		writes = append(writes, reg64(o.reg))
+	}
+	src := func(os ...asmOperand) {
+		for _, o := range os {
+			reads = append(reads, o.regs()...)
+		}
+	}
+
+	switch in.op {
+	case "mov", "movsx", "movsxd", "movzx", "lea":
+		src(in.args[1])
+		dest(in.args[0])
+	case "add", "sub", "imul", "and", "or", "xor", "shl", "sar":
+		if len(in.args) == 3 {
+			src(in.args[1:]...)
+		} else {
+			src(in.args...)
+		}
+		dest(in.args[0])
+	case "cmp", "test":
+		src(in.args...)
+	case "sete", "setne", "setl", "setle", "setg", "setge":
+		dest(in.args[0])
+	case "push":
+		src(in.args...)
+		return reads, writes, true
+	case "pop":
+		dest(in.args[0])
+		return reads, writes, true
+	case "cqo":
+		return []string{"rax"}, []string{"rdx"}, false
+	case "idiv":
+		src(in.args...)
+		return append(reads, "rax", "rdx"), []string{"rax", "rdx"}, false
+	case "call":
+		return argregs64, callerSavedAll, true
+	default:
+		return nil, nil, true
+	}
+	return reads, writes, false
+}
+
+func (in *asmInst) reads(r string) bool {
+	reads, _, _ := in.effects()
+	return slices.Contains(reads, r)
+}
+
+func (in *asmInst) writes(r string) bool {
+	_, writes, _ := in.effects()
+	return slices.Contains(writes, r)
+}
+
+// touches は命令がレジスタ r を読むか書くかを返す
+func (in *asmInst) touches(r string) bool {
+	return in.reads(r) || in.writes(r)
+}
+
+// deadAfter は位置 i の命令の後でレジスタ r の値が使われないかどうかを返す
+func (p *peepholer) deadAfter(i int, r string) bool {
+	for _, in := range p.insts[i+1:] {
+		if in.meta {
+			continue
+		}
+		if in.kind != asmInsn {
+			return false
+		}
+		switch in.op {
+		case "ret":
+			return !slices.Contains(append([]string{"rax", "rsp", "rbp"}, calleeSavedRegs...), r)
+		case "call":
+			if slices.Contains(argregs64, r) {
+				return false
+			}
+			if slices.Contains(callerSavedAll, r) {
+				return true
+			}
+			continue
+		}
+		reads, writes, barrier := in.effects()
+		if slices.Contains(reads, r) {
+			return false
+		}
+		if slices.Contains(writes, r) {
+			return true
+		}
+		if barrier && in.op != "push" && in.op != "pop" {
+			return false
+		}
+	}
+	return false
+}
+
+// nextUse は i の後で最初にレジスタ r を読み書きする命令の位置を返す。
+// その前に barrier があるか、ok が偽を返す命令があれば -1。
+func (p *peepholer) nextUse(i int, r string, ok func(in *asmInst) bool) int {
+	for k := i + 1; k < len(p.insts); k++ {
+		in := p.insts[k]
+		_, _, barrier := in.effects()
+		if in.kind == asmInsn && in.touches(r) {
+			return k
+		}
+		if barrier || !ok(in) {
+			return -1
+		}
+	}
+	return -1
+}
+
+// pushPop: push X ... pop Y を mov Y, X にする（X と Y が同じレジスタなら両方消す）。
+// 間の命令はスタックを使わず、X のレジスタを書き換えないものに限る。
+func (p *peepholer) pushPop(i int) bool {
+	if !p.insn(i, "push") {
+		return false
+	}
+	x := p.insts[i].args[0]
+	if x.kind != opdReg && x.kind != opdImm {
+		return false
+	}
+	for k := i + 1; k < len(p.insts); k++ {
+		in := p.insts[k]
+		if p.insn(k, "pop") {
+			y := in.args[0]
+			if x.kind == opdReg && reg64(x.reg) == reg64(y.reg) {
+				p.remove(k)
+			} else {
+				p.insts[k] = &asmInst{kind: asmInsn, op: "mov", args: []asmOperand{y, x}}
+			}
+			p.remove(i)
+			return true
+		}
+		_, _, barrier := in.effects()
+		if barrier || in.touches("rsp") || x.kind == opdReg && in.writes(reg64(x.reg)) {
+			return false
+		}
+	}
+	return false
+}
+
+// frameAddr: mov R, rbp / sub R, N を lea R, [rbp - N] にする
+func (p *peepholer) frameAddr(i int) bool {
+	if i+1 >= len(p.insts) || !p.insn(i, "mov") || !p.insn(i+1, "sub") {
+		return false
+	}
+	mov, sub := p.insts[i], p.insts[i+1]
+	if mov.args[0].kind != opdReg || mov.args[1] != regOp("rbp") ||
+		sub.args[0] != mov.args[0] || sub.args[1].kind != opdImm {
+		return false
+	}
+	p.insts[i] = &asmInst{kind: asmInsn, op: "lea", args: []asmOperand{mov.args[0], memOp("rbp", -sub.args[1].imm)}}
+	p.remove(i + 1)
+	return true
+}
+
+// foldAddr: lea R, [rbp - N] の後で R をアドレスとしてだけ使う命令に
+// [rbp - N] を直接書き、lea を消す（グローバル変数の sym[rip] も同様）。
+func (p *peepholer) foldAddr(i int) bool {
+	if !p.insn(i, "lea") {
+		return false
+	}
+	r, addr := p.insts[i].args[0], p.insts[i].args[1]
+	if r.kind != opdReg || regSizes[r.reg] != 8 || addr.index != "" || addr.base == r.reg {
+		return false
+	}
+	k := p.nextUse(i, r.reg, func(in *asmInst) bool {
+		return addr.base == "" || !in.writes(addr.base)
+	})
+	if k < 0 {
+		return false
+	}
+	in := p.insts[k]
+	m := -1
+	for j, a := range in.args {
+		if a.kind == opdMem && a.base == r.reg && a.index == "" {
+			m = j
+		}
+	}
+	if m < 0 || addr.sym != "" && in.args[m].disp != 0 {
+		return false
+	}
+	folded := addr
+	folded.disp += in.args[m].disp
+	folded.size = in.args[m].size
+	cand := &asmInst{kind: asmInsn, op: in.op, args: slices.Clone(in.args)}
+	cand.args[m] = folded
+	if cand.reads(r.reg) { // アドレス以外にも使っている
+		return false
+	}
+	if !in.writes(r.reg) && !p.deadAfter(k, r.reg) {
+		return false
+	}
+	p.insts[k] = cand
+	p.remove(i)
+	return true
+}
+
+// foldImm: mov R, imm の後で R を 1 度だけ読む命令に即値を直接書き、mov を消す
+func (p *peepholer) foldImm(i int) bool {
+	if !p.insn(i, "mov") {
+		return false
+	}
+	r, v := p.insts[i].args[0], p.insts[i].args[1]
+	if r.kind != opdReg || regSizes[r.reg] != 8 || v.kind != opdImm || v.imm < math.MinInt32 || v.imm > math.MaxInt32 {
+		return false
+	}
+	k := p.nextUse(i, r.reg, func(*asmInst) bool { return true })
+	if k < 0 {
+		return false
+	}
+	in := p.insts[k]
+	var folded *asmInst
+	switch in.op {
+	case "add", "sub", "cmp", "and", "or", "xor":
+		if len(in.args) == 2 && in.args[1] == r && !slices.Contains(in.args[0].regs(), r.reg) {
+			folded = &asmInst{kind: asmInsn, op: in.op, args: []asmOperand{in.args[0], v}}
+		}
+	case "imul":
+		if len(in.args) == 2 && in.args[1] == r && in.args[0].kind == opdReg && in.args[0] != r {
+			folded = &asmInst{kind: asmInsn, op: "imul", args: []asmOperand{in.args[0], in.args[0], v}}
+		}
+	case "mov":
+		src, dst := in.args[1], in.args[0]
+		if src.kind != opdReg || reg64(src.reg) != r.reg || slices.Contains(dst.regs(), r.reg) {
+			break
+		}
+		imm := v
+		switch regSizes[src.reg] {
+		case 1:
+			imm.imm = int(int8(v.imm))
+		case 4:
+			imm.imm = int(int32(v.imm))
+		}
+		if dst.kind == opdMem {
+			dst = dst.ptr(regSizes[src.reg])
+		}
+		folded = &asmInst{kind: asmInsn, op: "mov", args: []asmOperand{dst, imm}}
+	case "push":
+		if in.args[0] == r {
+			folded = &asmInst{kind: asmInsn, op: "push", args: []asmOperand{v}}
+		}
+	}
+	if folded == nil || !p.deadAfter(k, r.reg) {
+		return false
+	}
+	p.insts[k] = folded
+	p.remove(i)
+	return true
+}
+
+// selfMov: 64 ビットの mov R, R を消す（mov eax, eax は上位 32 ビットを 0 にするので残す）
+func (p *peepholer) selfMov(i int) bool {
+	if !p.insn(i, "mov") {
+		return false
+	}
+	a := p.insts[i].args
+	if a[0].kind != opdReg || a[0] != a[1] || regSizes[a[0].reg] != 8 {
+		return false
+	}
+	p.remove(i)
+	return true
+}
+
+// deadMov: 結果が使われないレジスタへの mov・lea を消す
+func (p *peepholer) deadMov(i int) bool {
+	in := p.insts[i]
+	if in.kind != asmInsn || in.op != "mov" && in.op != "lea" && in.op != "movzx" {
+		return false
+	}
+	r := in.args[0]
+	if r.kind != opdReg || regSizes[r.reg] < 4 || r.reg == "rsp" || r.reg == "rbp" || !p.deadAfter(i, reg64(r.reg)) {
+		return false
+	}
+	p.remove(i)
+	return true
+}
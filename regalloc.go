@@ -0,0 +1,241 @@
+package g9cc
+
+import "sort"
+
+// IR の仮想レジスタを x86-64 のレジスタに割り当てる（線形走査法）。
+//
+// 命令にブロックの並び順で通し番号を振り、ブロック単位の生存解析から
+// 仮想レジスタごとに穴のない生存区間 [start, end] を求める。
+// call をまたいで生きる区間には callee-saved レジスタだけを使い、
+// 割り当てられなかった区間はフレーム上のスロットに spill する。
+// rax, rdx（除算）と r11 は命令の展開に使うので割り当てない。
+
+var calleeSavedRegs = []string{"rbx", "r12", "r13", "r14", "r15"}
+var callerSavedRegs = []string{"rcx", "rsi", "rdi", "r8", "r9", "r10"}
+
+type interval struct {
+	reg         int
+	start, end  int
+	crossesCall bool
+}
+
+// regAlloc は割り当て結果
+type regAlloc struct {
+	regs        []string // 仮想レジスタごとの x86 レジスタ（spill したものは ""）
+	spilled     []bool
+	usedCallee  []string // 使った callee-saved レジスタ（プロローグで退避する）
+	numSpilled  int
+	numAssigned int
+}
+
+// liveness はブロックごとの生存解析を行い、ブロック入口で生きている仮想レジスタを返す
+func liveness(f *irFunc) (liveIn, liveOut []map[int]bool) {
+	n := len(f.blocks)
+	use := make([]map[int]bool, n)
+	def := make([]map[int]bool, n)
+	for i, b := range f.blocks {
+		use[i], def[i] = map[int]bool{}, map[int]bool{}
+		for _, inst := range b.insts {
+			for _, a := range inst.args {
+				if !def[i][a] {
+					use[i][a] = true
+				}
+			}
+			if inst.dst != 0 {
+				def[i][inst.dst] = true
+			}
+		}
+	}
+
+	liveIn = make([]map[int]bool, n)
+	liveOut = make([]map[int]bool, n)
+	for i := range f.blocks {
+		liveIn[i], liveOut[i] = map[int]bool{}, map[int]bool{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			b := f.blocks[i]
+			for _, s := range b.succs() {
+				for r := range liveIn[s.id] {
+					if !liveOut[i][r] {
+						liveOut[i][r] = true
+						changed = true
+					}
+				}
+			}
+			for r := range use[i] {
+				if !liveIn[i][r] {
+					liveIn[i][r] = true
+					changed = true
+				}
+			}
+			for r := range liveOut[i] {
+				if !def[i][r] && !liveIn[i][r] {
+					liveIn[i][r] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
+
+// buildIntervals は仮想レジスタごとの生存区間を求める
+func buildIntervals(f *irFunc) []*interval {
+	f.renumber()
+	liveIn, liveOut := liveness(f)
+
+	ivs := make([]*interval, len(f.types))
+	extend := func(r, pos int) {
+		iv := ivs[r]
+		if iv == nil {
+			ivs[r] = &interval{reg: r, start: pos, end: pos}
+			return
+		}
+		iv.start = min(iv.start, pos)
+		iv.end = max(iv.end, pos)
+	}
+
+	var calls []int
+	pos := 0
+	for i, b := range f.blocks {
+		start := pos
+		for r := range liveIn[i] {
+			extend(r, start)
+		}
+		for _, inst := range b.insts {
+			for _, a := range inst.args {
+				extend(a, pos)
+			}
+			if inst.dst != 0 {
+				extend(inst.dst, pos)
+			}
+			if inst.op == irCall {
+				calls = append(calls, pos)
+			}
+			pos++
+		}
+		for r := range liveOut[i] {
+			extend(r, pos-1)
+		}
+	}
+
+	var out []*interval
+	for _, iv := range ivs {
+		if iv == nil {
+			continue
+		}
+		for _, c := range calls {
+			if iv.start < c && c < iv.end {
+				iv.crossesCall = true
+				break
+			}
+		}
+		out = append(out, iv)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start < out[j].start })
+	return out
+}
+
+// allocRegs は線形走査法で仮想レジスタにレジスタを割り当てる
+func allocRegs(f *irFunc) *regAlloc {
+	ra := &regAlloc{regs: make([]string, len(f.types)), spilled: make([]bool, len(f.types))}
+	free := map[string]bool{}
+	for _, r := range calleeSavedRegs {
+		free[r] = true
+	}
+	for _, r := range callerSavedRegs {
+		free[r] = true
+	}
+	usedCallee := map[string]bool{}
+
+	var active []*interval // end の昇順
+	insertActive := func(iv *interval) {
+		i := sort.Search(len(active), func(i int) bool { return active[i].end > iv.end })
+		active = append(active, nil)
+		copy(active[i+1:], active[i:])
+		active[i] = iv
+	}
+	spill := func(iv *interval) {
+		ra.spilled[iv.reg] = true
+		ra.numSpilled++
+	}
+	// call をまたがない区間は caller-saved を優先し、退避の要らない callee-saved を残す
+	pick := func(iv *interval) string {
+		if !iv.crossesCall {
+			for _, r := range callerSavedRegs {
+				if free[r] {
+					return r
+				}
+			}
+		}
+		for _, r := range calleeSavedRegs {
+			if free[r] {
+				return r
+			}
+		}
+		return ""
+	}
+
+	for _, iv := range buildIntervals(f) {
+		// 区間の開始と同じ位置で終わる区間のレジスタは再利用しない。
+		// 命令の結果とオペランドが別のレジスタになるようにするため。
+		for len(active) > 0 && active[0].end < iv.start {
+			free[ra.regs[active[0].reg]] = true
+			active = active[1:]
+		}
+
+		reg := pick(iv)
+		if reg == "" {
+			// 使えるレジスタを持つ区間のうち最も遠くまで生きるものと比べ、遠い方を spill する
+			var victim *interval
+			for _, a := range active {
+				if iv.crossesCall && !isCalleeSaved(ra.regs[a.reg]) {
+					continue
+				}
+				if victim == nil || a.end > victim.end {
+					victim = a
+				}
+			}
+			if victim == nil || victim.end <= iv.end {
+				spill(iv)
+				continue
+			}
+			reg = ra.regs[victim.reg]
+			ra.regs[victim.reg] = ""
+			spill(victim)
+			for i, a := range active {
+				if a == victim {
+					active = append(active[:i], active[i+1:]...)
+					break
+				}
+			}
+			ra.numAssigned--
+		}
+
+		free[reg] = false
+		ra.regs[iv.reg] = reg
+		ra.numAssigned++
+		if isCalleeSaved(reg) {
+			usedCallee[reg] = true
+		}
+		insertActive(iv)
+	}
+
+	for _, r := range calleeSavedRegs {
+		if usedCallee[r] {
+			ra.usedCallee = append(ra.usedCallee, r)
+		}
+	}
+	return ra
+}
+
+func isCalleeSaved(reg string) bool {
+	for _, r := range calleeSavedRegs {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,41 @@
-package main
+package g9cc
 
 import "fmt"
 
-func sema(prog *obj) error {
+// checker は型付け中の状態を保持する
+type checker struct {
+	input   string          // エラー位置の表示に使う入力
+	diags   *diagSink       // 文ごとのエラー・警告の記録先
+	funcs   map[string]*obj // 翻訳単位で定義された関数
+	maxArgs int             // ターゲットで渡せる引数の数
+}
+
+func sema(prog *obj, input string, diags *diagSink, maxArgs int) error {
+	c := &checker{input: input, diags: diags, funcs: map[string]*obj{}, maxArgs: maxArgs}
+	for fn := prog; fn != nil; fn = fn.next {
+		if fn.isFunction {
+			c.funcs[*fn.name] = fn
+		}
+	}
 	for fn := prog; fn != nil; fn = fn.next {
-		if err := addType(fn.body); err != nil {
+		if !fn.isFunction {
+			continue
+		}
+		if err := c.addType(fn.body); err != nil {
+			return err
+		}
+		// エラーのあった文は型が付いていないので、AST をたどる以降のパスは走らせない
+		// （-Werror でエラーにした警告は AST を壊さないので数えない）
+		if c.diags.nerrors > c.diags.nwerrors {
+			continue
+		}
+		if err := c.warnUnused(fn); err != nil {
+			return err
+		}
+		if err := c.checkUninit(fn); err != nil {
+			return err
+		}
+		if err := c.checkFlow(fn); err != nil {
 			return err
 		}
 	}
@@ -15,9 +46,22 @@ func intType() *ty {
 	return &ty{kind: tyInt, size: 4}
 }
 
-func walk(nodes ...*node) error {
+func (c *checker) errorAt(node *node, format string, args ...any) error {
+	return errorTok(c.input, node.tok, fmt.Sprintf(format, args...))
+}
+
+// warn は node の位置に警告を出す
+func (c *checker) warn(category string, node *node, format string, args ...any) error {
+	pos := 0
+	if node.tok != nil {
+		pos = node.tok.pos
+	}
+	return c.diags.warn(category, c.input, pos, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) walk(nodes ...*node) error {
 	for _, n := range nodes {
-		if err := addType(n); err != nil {
+		if err := c.addType(n); err != nil {
 			return err
 		}
 	}
@@ -37,21 +81,54 @@ func normalizeArithmeticTypes(node *node) (lhsTy, rhsTy *ty) {
 	return lhsTy, rhsTy
 }
 
-func scalePtrIndex(node *node, ptrTy *ty) error {
-	scale := newNode(ndMul, node.rhs, newNodeNum(ptrTy.base.size))
-	if err := addType(scale); err != nil {
-		return err
-	}
+// scalePtrIndex は ptr + i の i に要素の大きさを掛ける。
+// i は型付け済みなので、もう一度 addType はしない（ポインタの差が二重に割られる）。
+func scalePtrIndex(node *node, ptrTy *ty) {
+	size := newNodeNum(ptrTy.base.size, node.tok)
+	size.ty = intType()
+	scale := newNode(ndMul, node.rhs, size, node.tok)
+	scale.ty = intType()
 	node.rhs = scale
 	node.ty = ptrTy
-	return nil
+}
+
+// isLvalue はアドレスを持つ式かどうかを返す
+func isLvalue(node *node) bool {
+	return node.kind == ndVar || node.kind == ndDeref
 }
 
 func isIntegerType(t *ty) bool {
 	return t.kind == tyInt || t.kind == tyChar
 }
 
-func typeAdd(node *node) error {
+func isPointerLike(t *ty) bool {
+	return t.kind == tyPtr || t.kind == tyArray
+}
+
+// checkCompare はポインタと整数の比較を警告する（==, != の 0 は null ポインタ定数として許す）
+func (c *checker) checkCompare(node *node) error {
+	ptr, num := node.lhs, node.rhs
+	if !isPointerLike(ptr.ty) {
+		ptr, num = num, ptr
+	}
+	if !isPointerLike(ptr.ty) || !isIntegerType(num.ty) {
+		return nil
+	}
+	if (node.kind == ndEq || node.kind == ndNe) && num.kind == ndNum && num.val == 0 {
+		return nil
+	}
+	return c.warn(WarnPointerIntegerCmp, node, "comparison between pointer and integer ('%s' and '%s')", node.lhs.ty, node.rhs.ty)
+}
+
+// checkCondition は括弧で囲まれていない代入が条件に使われていれば警告する
+func (c *checker) checkCondition(cond *node) error {
+	if cond == nil || cond.kind != ndAssign || cond.paren {
+		return nil
+	}
+	return c.warn(WarnParentheses, cond, "suggest parentheses around assignment used as truth value")
+}
+
+func (c *checker) typeAdd(node *node) error {
 	lhsTy, rhsTy := normalizeArithmeticTypes(node)
 
 	// num + num
@@ -68,25 +145,15 @@ func typeAdd(node *node) error {
 
 	// ptr + num
 	if lhsTy.kind == tyPtr && isIntegerType(rhsTy) {
-		if err := scalePtrIndex(node, lhsTy); err != nil {
-			return err
-		}
+		scalePtrIndex(node, lhsTy)
 		return nil
 	}
 
-	return fmt.Errorf("invalid operands for +")
+	return c.errorAt(node, "invalid operands to binary + (have '%s' and '%s')", node.lhs.ty, node.rhs.ty)
 }
 
-func typeSub(node *node) error {
-	lhsTy := node.lhs.ty
-	rhsTy := node.rhs.ty
-
-	if lhsTy.kind == tyArray {
-		lhsTy = pointerTo(lhsTy.base)
-	}
-	if rhsTy.kind == tyArray {
-		rhsTy = pointerTo(rhsTy.base)
-	}
+func (c *checker) typeSub(node *node) error {
+	lhsTy, rhsTy := normalizeArithmeticTypes(node)
 
 	// num - num
 	if isIntegerType(lhsTy) && isIntegerType(rhsTy) {
@@ -96,64 +163,103 @@ func typeSub(node *node) error {
 
 	// ptr - num
 	if lhsTy.kind == tyPtr && isIntegerType(rhsTy) {
-		if err := scalePtrIndex(node, lhsTy); err != nil {
-			return err
-		}
+		scalePtrIndex(node, lhsTy)
 		return nil
 	}
 
 	// ptr - ptr
 	if lhsTy.kind == tyPtr && rhsTy.kind == tyPtr {
-		sub := newNode(ndSub, node.lhs, node.rhs)
+		sub := newNode(ndSub, node.lhs, node.rhs, node.tok)
 		sub.ty = intType()
 
 		node.kind = ndDiv
 		node.lhs = sub
-		node.rhs = newNodeNum(lhsTy.base.size)
+		node.rhs = newNodeNum(lhsTy.base.size, node.tok)
+		node.rhs.ty = intType()
 		node.ty = intType()
 		return nil
 	}
 
-	return fmt.Errorf("invalid operands for -")
+	return c.errorAt(node, "invalid operands to binary - (have '%s' and '%s')", node.lhs.ty, node.rhs.ty)
 }
 
-func addType(node *node) error {
+func (c *checker) addType(node *node) error {
 	if node == nil {
 		return nil
 	}
-	if err := walk(node.next, node.lhs, node.rhs, node.cond, node.then, node.els, node.init, node.inc); err != nil {
+	if node.kind == ndBlock {
+		// 文ごとにエラーを記録し、残りの文の検査を続ける
+		for stmt := node.lhs; stmt != nil; stmt = stmt.next {
+			if err := c.addType(stmt); err != nil {
+				if err := c.diags.report(err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := c.walk(node.lhs, node.rhs, node.cond, node.then, node.els, node.init, node.inc); err != nil {
 		return err
 	}
 
 	switch node.kind {
 	case ndAdd:
-		return typeAdd(node)
+		return c.typeAdd(node)
 	case ndSub:
-		return typeSub(node)
+		return c.typeSub(node)
 	case ndNe:
 		node.ty = node.lhs.ty
-		return nil
+		return c.checkCompare(node)
 	case ndAssign:
-		if node.lhs.ty.kind == tyArray {
-			return fmt.Errorf("not an lvalue")
+		if !isLvalue(node.lhs) || node.lhs.ty.kind == tyArray {
+			return c.errorAt(node, "not an lvalue (have '%s')", node.lhs.ty)
+		}
+		if node.lhs.ty.isConst && !node.isInit {
+			if node.lhs.kind == ndVar {
+				return c.errorAt(node, "assignment of read-only variable '%s'", *node.lhs.lvar.name)
+			}
+			return c.errorAt(node, "assignment of read-only location")
 		}
 		node.ty = node.lhs.ty
 		return nil
-	case ndMul, ndDiv, ndEq, ndLt, ndLe, ndNum:
+	case ndEq, ndLt, ndLe:
+		node.ty = intType()
+		return c.checkCompare(node)
+	case ndMul, ndDiv, ndNum:
 		node.ty = intType()
 		return nil
 	case ndFuncall:
+		if len(node.args) > c.maxArgs {
+			return c.errorAt(node, "too many arguments to function %s: max %d", node.funcname, c.maxArgs)
+		}
 		for _, arg := range node.args {
-			if err := addType(arg); err != nil {
+			if err := c.addType(arg); err != nil {
 				return err
 			}
 		}
 		node.ty = intType()
+		fn, ok := c.funcs[node.funcname]
+		if !ok {
+			return c.warn(WarnImplicitFunctionDecl, node, "implicit declaration of function '%s'", node.funcname)
+		}
+		nparams := 0
+		for v := fn.params; v != nil; v = v.next {
+			nparams++
+		}
+		if len(node.args) > nparams {
+			return c.errorAt(node, "too many arguments to function %s (expected %d, have %d)", node.funcname, nparams, len(node.args))
+		}
+		if len(node.args) < nparams {
+			return c.errorAt(node, "too few arguments to function %s (expected %d, have %d)", node.funcname, nparams, len(node.args))
+		}
 		return nil
 	case ndVar:
 		node.ty = node.lvar.ty
 		return nil
 	case ndAddr:
+		if !isLvalue(node.lhs) {
+			return c.errorAt(node, "lvalue required as unary '&' operand")
+		}
 		if node.lhs.ty.kind == tyArray {
 			node.ty = pointerTo(node.lhs.ty.base)
 		} else {
@@ -161,11 +267,10 @@ func addType(node *node) error {
 		}
 		return nil
 	case ndDeref:
-		if node.lhs.ty.base != nil {
-			node.ty = node.lhs.ty.base
-		} else {
-			node.ty = intType()
+		if node.lhs.ty.base == nil {
+			return c.errorAt(node, "invalid operand to unary '*' (have '%s')", node.lhs.ty)
 		}
+		node.ty = node.lhs.ty.base
 		return nil
 	case ndSizeof:
 		node.ty = intType()
@@ -173,10 +278,14 @@ func addType(node *node) error {
 		node.val = node.lhs.ty.size
 		node.rhs = nil
 		node.lhs = nil
-	case ndExprStmt, ndReturn, ndIf, ndWhile, ndFor, ndBlock:
+	case ndIf, ndFor:
+		return c.checkCondition(node.cond)
+	case ndWhile:
+		return c.checkCondition(node.lhs)
+	case ndExprStmt, ndReturn:
 		return nil
 	default:
-		return fmt.Errorf("internal error: unknown node kind: %d", node.kind)
+		return c.errorAt(node, "internal error: unknown node kind: %d", node.kind)
 	}
 	return nil
 }
@@ -0,0 +1,43 @@
+package g9cc
+
+import "math/bits"
+
+// strengthReduce は 2 の累乗の定数との乗算をシフトにし、
+// ポインタ演算の p + i*4（sema の scalePtrIndex が作る乗算）を lea 1 命令にまとめる。
+// 使われなくなったシフトは後の dce で消す。
+func strengthReduce(f *irFunc) {
+	defs := f.defs()
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			if inst.op != irMul {
+				continue
+			}
+			for _, j := range [][2]int{{0, 1}, {1, 0}} {
+				c, ok := constOf(defs, inst.args[j[1]])
+				if !ok || c <= 1 || c&(c-1) != 0 {
+					continue
+				}
+				x := inst.args[j[0]]
+				*inst = irInst{op: irShl, dst: inst.dst, args: []int{x}, imm: bits.TrailingZeros(uint(c)), tok: inst.tok}
+				break
+			}
+		}
+	}
+
+	uses := f.useCounts()
+	for _, b := range f.blocks {
+		for _, inst := range b.insts {
+			if inst.op != irAdd {
+				continue
+			}
+			for _, j := range [][2]int{{0, 1}, {1, 0}} {
+				s := defs[inst.args[j[1]]]
+				if s == nil || s.op != irShl || s.imm > 3 || uses[s.dst] != 1 {
+					continue
+				}
+				*inst = irInst{op: irLea, dst: inst.dst, args: []int{inst.args[j[0]], s.args[0]}, imm: s.imm, tok: inst.tok}
+				break
+			}
+		}
+	}
+}
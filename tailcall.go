@@ -0,0 +1,24 @@
+package g9cc
+
+// 末尾呼び出しの最適化（-O2）。
+// call の結果をそのまま返す（または値なしで戻る）ブロックの末尾を tailcall にし、
+// コード生成でフレームを畳んでから jmp させる。再帰が深くてもスタックが伸びない。
+
+func tailCalls(f *irFunc) {
+	// ローカル変数のアドレスが呼び出し先に渡りうるなら、フレームを先に畳めない
+	if len(f.escapedLocals()) > 0 {
+		return
+	}
+	for _, b := range f.blocks {
+		n := len(b.insts)
+		if n < 2 {
+			continue
+		}
+		call, ret := b.insts[n-2], b.insts[n-1]
+		if call.op != irCall || ret.op != irRet || len(ret.args) > 0 && ret.args[0] != call.dst {
+			continue
+		}
+		call.op, call.dst = irTailCall, 0
+		b.insts = b.insts[:n-1]
+	}
+}
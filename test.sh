@@ -1,6 +1,60 @@
 #!/usr/bin/env bash
 tmpdir="${TMPDIR:-.tmp-work}"
-cat <<EOF | gcc -xc -c -o $tmpdir/tmp2.o -
+
+# G9CCTARGET を指定するとクロスコンパイラでリンクし、エミュレータで実行する。
+# CC と RUN で上書きできる。
+case "$G9CCTARGET" in
+"") ;;
+aarch64-linux)
+    CC="${CC:-aarch64-linux-gnu-gcc}"
+    RUN="${RUN:-qemu-aarch64 -L /usr/aarch64-linux-gnu}"
+    ;;
+riscv64-linux)
+    CC="${CC:-riscv64-linux-gnu-gcc}"
+    RUN="${RUN:-qemu-riscv64 -L /usr/riscv64-linux-gnu}"
+    ;;
+wasm32-wasi)
+    # リンクはせず、テスト用の関数は env モジュールとして wasmtime に読み込ませる
+    CC="${CC:-wasm_link}"
+    RUN="${RUN:-wasmtime run --preload env=$tmpdir/tmp2.wat}"
+    ;;
+*)
+    echo "unknown G9CCTARGET: $G9CCTARGET"
+    exit 1
+    ;;
+esac
+CC="${CC:-gcc}"
+if [ -n "$G9CCTARGET" ]; then
+    G9CCFLAGS="$G9CCFLAGS -target $G9CCTARGET"
+fi
+
+# G9CCFLAGS に -emit-llvm を含めると、出力した LLVM IR を llc でアセンブリにしてから実行する。
+# LLVM 14 の llc では LLCFLAGS=-opaque-pointers が要る。
+LLC="${LLC:-llc}"
+case " $G9CCFLAGS " in
+*" -emit-llvm "*) EMITLLVM=1 ;;
+esac
+
+# wasm_link は -o <out> <file.s> ... の WAT をそのまま実行するファイルにする
+wasm_link() {
+    cp "$3" "$2"
+}
+
+mkdir -p "$tmpdir"
+if [ "$G9CCTARGET" = wasm32-wasi ]; then
+cat <<EOF > $tmpdir/tmp2.wat
+(module
+  (func (export "ret3") (result i32) i32.const 3)
+  (func (export "ret5") (result i32) i32.const 5)
+  (func (export "add") (param i32 i32) (result i32) local.get 0 local.get 1 i32.add)
+  (func (export "sub") (param i32 i32) (result i32) local.get 0 local.get 1 i32.sub)
+  (func (export "add6") (param i32 i32 i32 i32 i32 i32) (result i32)
+    local.get 0 local.get 1 i32.add local.get 2 i32.add
+    local.get 3 i32.add local.get 4 i32.add local.get 5 i32.add)
+)
+EOF
+else
+cat <<EOF | $CC -xc -c -o $tmpdir/tmp2.o -
 int ret3() { return 3; }
 int ret5() { return 5; }
 int add(int x, int y) { return x+y; }
@@ -10,6 +64,7 @@ int add6(int a, int b, int c, int d, int e, int f) {
   return a+b+c+d+e+f;
 }
 EOF
+fi
 
 assert() {
     expected="$1"
@@ -17,11 +72,25 @@ assert() {
 
     mkdir -p "$tmpdir"
 
-    ./g9cc "$input" > "$tmpdir/tmp.s"
-    gcc -o "$tmpdir/tmp" "$tmpdir/tmp.s" "$tmpdir/tmp2.o"
-    "$tmpdir/tmp"
+    if [ -n "$EMITLLVM" ]; then
+        ./g9cc $G9CCFLAGS "$input" > "$tmpdir/tmp.ll"
+        $LLC $LLCFLAGS -relocation-model=pic -o "$tmpdir/tmp.s" "$tmpdir/tmp.ll"
+    else
+        ./g9cc $G9CCFLAGS "$input" > "$tmpdir/tmp.s"
+    fi
+    $CC -o "$tmpdir/tmp" "$tmpdir/tmp.s" "$tmpdir/tmp2.o"
+    $RUN "$tmpdir/tmp"
     actual="$?"
 
+    # -emit-c で書き戻した C は、もう一度書き戻しても変わらず、解析すると元と同じ AST になる
+    # （AST は -emit-c=desugared で書いて比べる）
+    ./g9cc -emit-c "$input" > "$tmpdir/rt.c" 2>/dev/null
+    if ! ./g9cc -emit-c "$tmpdir/rt.c" 2>/dev/null | cmp -s "$tmpdir/rt.c" - ||
+        ! ./g9cc -emit-c=desugared "$tmpdir/rt.c" 2>/dev/null | cmp -s <(./g9cc -emit-c=desugared "$input" 2>/dev/null) -; then
+        echo "$input => -emit-c does not round-trip"
+        exit 1
+    fi
+
     if [ "$actual" = "$expected" ]; then
         echo "$input => $actual"
     else
@@ -56,6 +125,11 @@ assert 2 'int x[4]; int main() { x[0]=0; x[1]=1; x[2]=2; x[3]=3; return x[2]; }'
 assert 3 'int x[4]; int main() { x[0]=0; x[1]=1; x[2]=2; x[3]=3; return x[3]; }'
 
 assert 4 'int x; int main() { return sizeof(x); }'
+assert 24 'int x[2*3]; int main() { return sizeof(x); }'
+assert 6 'int main() { int x[2+sizeof(1)]; return sizeof(x)/4 - 0*1; }'
+assert 13 'int x=3*4+1; int main() { return x; }'
+assert 44 'char c=300; int main() { return c; }'
+assert 5 'int x=2, y=3; int main() { return x+y; }'
 assert 16 'int x[4]; int main() { return sizeof(x); }'
 
 assert 3 'int main() { int x[3]; *x=3; x[1]=4; x[2]=5; return *x; }'
@@ -132,6 +206,8 @@ assert 3 'int main() { for (;;) return 3; return 5; }'
 assert 10 'int main() { int i=0; while(i<10) i=i+1; return i; }'
 
 assert 3 'int main() { {1; {2;} return 3;} }'
+assert 0 'int main() { int x=7; x+35; }'
+assert 0 'int main() { int x=7; if (x) x=1; }'
 assert 5 'int main() { ;;; return 5; }'
 
 assert 10 'int main() { int i=0; while(i<10) i=i+1; return i; }'
@@ -146,6 +222,7 @@ assert 5 'int main() { int x=3; int *y=&x; *y=5; return x; }'
 assert 7 'int main() { int x=3; int y=5; *(&x-1)=7; return y; }'
 assert 7 'int main() { int x=3; int y=5; *(&y+2-1)=7; return x; }'
 assert 5 'int main() { int x=3; return (&x+2)-&x+3; }'
+assert 7 'int main() { int a[4]; int *p=a; int *q=a+2; a[2]=7; return a[q-p]; }'
 assert 8 'int main() { int x, y; x=3; y=5; return x+y; }'
 assert 8 'int main() { int x=3, y=5; return x+y; }'
 
@@ -156,11 +233,23 @@ assert 2 'int main() { return sub(5, 3); }'
 assert 21 'int main() { return add6(1,2,3,4,5,6); }'
 assert 66 'int main() { return add6(1,2,add6(3,4,5,6,7,8),9,10,11); }'
 assert 136 'int main() { return add6(1,2,add6(3,add6(4,5,6,7,8,9),10,11,12,13),14,15,16); }'
+assert 21 'int main() { int a=1; int b=2; return a + ret3() * b + add6(a,b,a,b,a,ret5()) + a*b; }'
+assert 57 'int main() { int a=1; int b=2; int c=3; return (a+b)*(b+c)*(c+a)+(a*b+b*c+c*a)*(a+(b+(c+(a+(b+(c+(a+(b+(c+(a+(b+c)))))))))))-add(a,add(b,add(c,ret5()))); }'
 
 assert 32 'int main() { return ret32(); } int ret32() { return 32; }'
 assert 7 'int main() { return add2(3,4); } int add2(int x, int y) { return x+y; }'
 assert 1 'int main() { return sub2(4,3); } int sub2(int x, int y) { return x-y; }'
 assert 55 'int main() { return fib(9); } int fib(int x) { if (x<=1) return 1; return fib(x-1) + fib(x-2); }'
+assert 12 'int main() { return gcd(84, 36); } int gcd(int a, int b) { if (b == 0) return a; return gcd(b, a - a / b * b); }'
+assert 231 'int main() { return swp(5, 1, 2, 3); } int swp(int n, int a, int b, int c) { if (n == 0) return a * 100 + b * 10 + c; return swp(n - 1, c, a, b); }'
+assert 20 'int two(int x) { if (x > 3) return x - 3; return x + 10; } int main() { return two(2) + two(11); }'
+assert 44 'int tc(char c) { return c; } int main() { return tc(300); }'
+assert 10 'inline int sq(int x) { return x * x; } int main() { int i; int s; s = 0; for (i = 0; i < 3; i = i + 1) s = s + sq(i); return s + add3(sq(1), 0, 0) + 4; } int add3(int a, int b, int c) { return a + b + c; }'
+
+assert 8 'static int n = 3; static int twice(int x) { return x * 2; } int main() { return twice(n) + 2; }'
+assert 7 'const int k = 3; int main() { const int x = 4; return k + x; }'
+assert 5 'int z = 0; int t; int main() { z = 2; t = 3; return z + t; }'
+assert 0 'char buf[32]; int main() { return buf[31]; }'
 
 assert 3 'int main() { int x[2]; int *y=&x; *y=3; return *x; }'
 
@@ -168,4 +257,214 @@ assert 3 'int main() { int x[3]; *x=3; *(x+1)=4; *(x+2)=5; return *x; }'
 assert 4 'int main() { int x[3]; *x=3; *(x+1)=4; *(x+2)=5; return *(x+1); }'
 assert 5 'int main() { int x[3]; *x=3; *(x+1)=4; *(x+2)=5; return *(x+2); }'
 
+# AArch64 と RISC-V は 8 個までの引数をレジスタで渡す
+case "$G9CCTARGET" in
+aarch64-linux | riscv64-linux)
+    assert 36 'int add8(int a, int b, int c, int d, int e, int f, int g, int h) { return a+b+c+d+e+f+g+h; } int main() { return add8(1, 2, 3, 4, 5, 6, 7, 8); }'
+    ;;
+esac
+
+# ここから先は x86-64 向けのアセンブリ出力だけを検査する
+if [ -n "$G9CCTARGET" ] || [ -n "$EMITLLVM" ]; then
+    echo OK
+    exit 0
+fi
+
+# -O2 では末尾呼び出しが jmp になり、深い再帰でもスタックが伸びない
+./g9cc -O2 'int sum(int n, int acc) { if (n == 0) return acc; return sum(n - 1, acc + 1); } int main() { return sum(10000000, 0) - sum(10000000, 0) / 256 * 256; }' > "$tmpdir/tmp.s"
+gcc -o "$tmpdir/tmp" "$tmpdir/tmp.s"
+"$tmpdir/tmp"
+actual="$?"
+if [ "$actual" != 128 ]; then
+    echo "-O2 tail call => 128 expected, but got $actual"
+    exit 1
+fi
+echo "-O2 tail call => $actual"
+
+# 文字列リテラルは .rodata に置くので、書き込むと SIGSEGV で落ちる
+./g9cc $G9CCFLAGS 'int main() { char *p; p = "abc"; *p = 1; return 0; }' > "$tmpdir/tmp.s"
+gcc -o "$tmpdir/tmp" "$tmpdir/tmp.s"
+"$tmpdir/tmp" 2>/dev/null
+actual="$?"
+if [ "$actual" != 139 ]; then
+    echo "write to string literal => 139 expected, but got $actual"
+    exit 1
+fi
+echo "write to string literal => $actual"
+
+# -fPIC で共有ライブラリを作り、既定の PIE の実行ファイルからリンクする
+./g9cc $G9CCFLAGS -fPIC testdata/pic/lib.c > "$tmpdir/lib.s"
+./g9cc $G9CCFLAGS -fPIC testdata/pic/main.c > "$tmpdir/main.s" 2>/dev/null
+gcc -shared -o "$tmpdir/libg9cctest.so" "$tmpdir/lib.s"
+gcc -pie -o "$tmpdir/tmp" "$tmpdir/main.s" -L"$tmpdir" -lg9cctest
+LD_LIBRARY_PATH="$tmpdir" "$tmpdir/tmp"
+actual="$?"
+if [ "$actual" != 105 ]; then
+    echo "-fPIC shared library => 105 expected, but got $actual"
+    exit 1
+fi
+echo "-fPIC shared library => $actual"
+
+# -g: デバッグ情報を付けても生成するコードは変わらず、変数と行番号が DWARF に載る
+./g9cc $G9CCFLAGS -g testdata/debug/vars.c > "$tmpdir/debug.s"
+gcc -o "$tmpdir/tmp" "$tmpdir/debug.s"
+"$tmpdir/tmp"
+actual="$?"
+if [ "$actual" != 7 ]; then
+    echo "-g => 7 expected, but got $actual"
+    exit 1
+fi
+if ! grep -v -e '^\.loc ' -e '^\.cfi_' -e '^\.file ' -e '^\.Ltext0:' -e '^\.L\..*\.end:' "$tmpdir/debug.s" | sed '/^\.Letext0:/,$d' |
+    diff -u <(./g9cc $G9CCFLAGS testdata/debug/vars.c) - > /dev/null; then
+    echo "-g => generated code differs from the one without -g"
+    exit 1
+fi
+info=$(readelf --debug-dump=info "$tmpdir/tmp" 2>/dev/null)
+for name in counter name scale v by r main x p arr; do
+    if ! grep -q "DW_AT_name *: $name\$" <<<"$info"; then
+        echo "-g => no debug info for $name"
+        exit 1
+    fi
+done
+if ! readelf --debug-dump=decodedline "$tmpdir/tmp" | grep -q 'vars.c  *14 '; then
+    echo "-g => no line info for line 14"
+    exit 1
+fi
+echo "-g => $actual"
+
+# -g: callee-saved レジスタを退避する関数の .cfi_* をゴールデンファイルと比べる
+if ! ./g9cc -g -O1 testdata/debug/callee.c | grep '^\.cfi_' | diff -u testdata/debug/callee.cfi -; then
+    echo "testdata/debug/callee.c => CFI differs from testdata/debug/callee.cfi"
+    exit 1
+fi
+echo "testdata/debug/callee.c => CFI ok"
+
+# -fverbose-asm: コメントを付けても生成するコードは変わらない
+./g9cc $G9CCFLAGS -fverbose-asm testdata/verbose/loop.c > "$tmpdir/verbose.s"
+gcc -o "$tmpdir/tmp" "$tmpdir/verbose.s"
+"$tmpdir/tmp"
+actual="$?"
+if [ "$actual" != 11 ]; then
+    echo "-fverbose-asm => 11 expected, but got $actual"
+    exit 1
+fi
+if ! grep -v '^	# ' "$tmpdir/verbose.s" | sed 's/:	# .*/:/' | diff -u <(./g9cc $G9CCFLAGS testdata/verbose/loop.c) - > /dev/null; then
+    echo "-fverbose-asm => generated code differs from the one without -fverbose-asm"
+    exit 1
+fi
+echo "-fverbose-asm => $actual"
+
+# -masm=att: AT&T 記法で出しても機械語は Intel 記法と同じになる
+for src in testdata/debug/vars.c testdata/verbose/loop.c testdata/pic/lib.c; do
+    ./g9cc $G9CCFLAGS -fPIC -masm=intel "$src" > "$tmpdir/intel.s"
+    ./g9cc $G9CCFLAGS -fPIC -masm=att "$src" > "$tmpdir/att.s"
+    gcc -c -o "$tmpdir/intel.o" "$tmpdir/intel.s"
+    gcc -c -o "$tmpdir/att.o" "$tmpdir/att.s"
+    if ! diff <(objdump -d -r "$tmpdir/intel.o" | tail -n +3) <(objdump -d -r "$tmpdir/att.o" | tail -n +3) > /dev/null; then
+        echo "$src => -masm=att and -masm=intel assemble differently"
+        exit 1
+    fi
+    echo "$src => -masm=att ok"
+done
+
+# グローバル変数のセクションの割り振りをゴールデンファイルと比べる
+for src in testdata/sections/*.c; do
+    if ! ./g9cc "$src" | sed '/^\.intel_syntax/,$d' | diff -u "${src%.c}.s" -; then
+        echo "$src => data sections differ from ${src%.c}.s"
+        exit 1
+    fi
+    echo "$src => data sections ok"
+done
+
+# -emit-ir の出力をゴールデンファイルと比べる
+for src in testdata/ir/*.c; do
+    if ! ./g9cc -emit-ir "$src" | diff -u "${src%.c}.ir" -; then
+        echo "$src => IR differs from ${src%.c}.ir"
+        exit 1
+    fi
+    echo "$src => IR ok"
+done
+
+# -O2 -emit-ir の出力（最適化後の IR）をゴールデンファイルと比べる
+for src in testdata/opt/*.c; do
+    if ! ./g9cc -O2 -emit-ir "$src" | diff -u "${src%.c}.ir" -; then
+        echo "$src => optimized IR differs from ${src%.c}.ir"
+        exit 1
+    fi
+    echo "$src => optimized IR ok"
+done
+
+# -emit-llvm の出力をゴールデンファイルと比べる
+for src in testdata/llvm/*.c; do
+    if ! ./g9cc -emit-llvm "$src" | diff -u "${src%.c}.ll" -; then
+        echo "$src => LLVM IR differs from ${src%.c}.ll"
+        exit 1
+    fi
+    echo "$src => LLVM IR ok"
+done
+
+# -emit-c はソースの書き方に戻した C を、-emit-c=desugared は AST のとおりの C を出す
+for src in testdata/cprint/*.c; do
+    if ! ./g9cc -emit-c "$src" | diff -u "$src" -; then
+        echo "$src => -emit-c differs from the source"
+        exit 1
+    fi
+    if ! ./g9cc -emit-c=desugared "$src" | diff -u "${src%.c}.desugared" -; then
+        echo "$src => -emit-c=desugared differs from ${src%.c}.desugared"
+        exit 1
+    fi
+    echo "$src => -emit-c ok"
+done
+
+# -ast-dump の出力（sema の前と後の木、JSON、dot）をゴールデンファイルと比べる
+for src in testdata/astdump/*.c; do
+    for dump in "-ast-dump ast" "-ast-dump -ast-dump-before-sema before-sema.ast" "-ast-dump=json json" "-ast-dump=dot dot"; do
+        ext="${dump##* }"
+        if ! ./g9cc ${dump% *} "$src" | diff -u "${src%.c}.$ext" -; then
+            echo "$src => ${dump% *} differs from ${src%.c}.$ext"
+            exit 1
+        fi
+    done
+    echo "$src => -ast-dump ok"
+done
+
+# 診断（エラー・警告）と終了コードをゴールデンファイルと比べる。
+# .flags があれば、その 1 行ごとのオプションで実行する。
+for src in testdata/diag/*.c; do
+    runs=$(cat "${src%.c}.flags" 2>/dev/null || echo)
+    actual=$(while IFS= read -r flags; do
+        echo "\$ g9cc${flags:+ $flags}"
+        ./g9cc $flags "$src" 2>&1 > /dev/null
+        echo "exit $?"
+    done <<<"$runs")
+    if ! diff -u "${src%.c}.err" <(echo "$actual"); then
+        echo "$src => diagnostics differ from ${src%.c}.err"
+        exit 1
+    fi
+    echo "$src => diagnostics ok"
+done
+
+# -fverbose-asm の出力をゴールデンファイルと比べる
+for src in testdata/verbose/*.c; do
+    if ! ./g9cc -fverbose-asm "$src" | diff -u "${src%.c}.s" -; then
+        echo "$src => annotated output differs from ${src%.c}.s"
+        exit 1
+    fi
+    echo "$src => annotated output ok"
+done
+# main の末尾で 0 を返すコードはソースの文に対応しないので、関数の先頭の行を書かない
+if ./g9cc -fir-codegen -fverbose-asm testdata/verbose/implicit.c | grep -q '# 1: '; then
+    echo "testdata/verbose/implicit.c => implicit return annotated with the function line"
+    exit 1
+fi
+
+# -fpeephole の出力（スタックマシンのコード生成 + peephole）をゴールデンファイルと比べる
+for src in testdata/peephole/*.c; do
+    if ! ./g9cc -fpeephole "$src" | diff -u "${src%.c}.s" -; then
+        echo "$src => peephole output differs from ${src%.c}.s"
+        exit 1
+    fi
+    echo "$src => peephole output ok"
+done
+
 echo OK
@@ -0,0 +1,8 @@
+int main() {
+  int a[4];
+  int *p = a;
+  int i = 1;
+  if (&a[3] - p > 2)
+    return sizeof(a) + -p[i];
+  return 0;
+}
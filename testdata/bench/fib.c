@@ -0,0 +1,9 @@
+int fib(int n) {
+  if (n < 2)
+    return n;
+  return fib(n - 1) + fib(n - 2);
+}
+
+int main() {
+  return fib(32) - 2178309;
+}
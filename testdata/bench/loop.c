@@ -0,0 +1,13 @@
+int main() {
+  int a[16];
+  int i;
+  int j;
+  int s;
+  for (i = 0; i < 16; i = i + 1)
+    a[i] = i;
+  s = 0;
+  for (j = 0; j < 5000000; j = j + 1)
+    for (i = 0; i < 16; i = i + 1)
+      s = s + a[i] * 3 - j;
+  return s - s / 256 * 256;
+}
@@ -0,0 +1,28 @@
+int grid[2][3];
+static const char *msg;
+extern int shared;
+char sign = -1;
+
+static inline int clamp(int v, int lo, int hi) {
+  if (v < lo)
+    return lo;
+  else if (v > hi)
+    return hi;
+  return v;
+}
+
+int main() {
+  int a[4], *p = a + 1, *q;
+  const int n = -4;
+  int i;
+  for (i = 0; i <= 3; i = i + 1)
+    a[i] = -i * 2;
+  q = &a[3];
+  while (q - p >= 1) {
+    *q = grid[1][q - a - 1] + - -n;
+    q = q - 1;
+  }
+  ;
+  msg = "ok";
+  return clamp(a[3] - (p[1] - 1) * 2, 0, 100) + msg[1] + sign;
+}
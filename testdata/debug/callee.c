@@ -0,0 +1,14 @@
+int id(int x) {
+  return x;
+}
+
+int sum(int a, int b, int c) {
+  int x = id(a);
+  int y = id(b);
+  int z = id(c);
+  return x + y + z + a + b + c;
+}
+
+int main() {
+  return sum(1, 2, 3);
+}
@@ -0,0 +1,18 @@
+int counter = 3;
+char name[8];
+
+int scale(int v, int by) {
+  int r;
+  r = v * by;
+  return r;
+}
+
+int main() {
+  int x;
+  int *p;
+  int arr[4];
+  x = scale(counter, 2);
+  p = &x;
+  arr[1] = *p;
+  return arr[1] + 1;
+}
@@ -0,0 +1,11 @@
+int foo(int a) {
+  return a;
+}
+
+int main() {
+  int x;
+  x = foo(1, 2);
+  x = foo();
+  x = foo(3) + bar(1, 2);
+  return x;
+}
@@ -0,0 +1,8 @@
+int main() {
+  int x;
+  int *p;
+  x = 1;
+  p = &x;
+  **p = 2;
+  return *x + *p;
+}
@@ -0,0 +1,7 @@
+int add7(int a, int b, int c, int d, int e, int f, int g) {
+  return a + b + c + d + e + f + g;
+}
+
+int main() {
+  return add7(1, 2, 3, 4, 5, 6, 7) + ext(1, 2, 3, 4, 5, 6, 7, 8, 9);
+}
@@ -0,0 +1,9 @@
+int main() {
+  int *p;
+  int *q;
+  return p + q;
+}
+
+int f(int x) {
+  return x - &x;
+}
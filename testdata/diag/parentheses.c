@@ -0,0 +1,9 @@
+int main() {
+  int x;
+  x = 0;
+  if (x = 2)
+    return x;
+  while ((x = 0))
+    return 1;
+  return 0;
+}
@@ -0,0 +1,9 @@
+int main() {
+  int x;
+  int *p;
+  x = 1;
+  p = &x;
+  if (p == 0)
+    return 1;
+  return p < 5;
+}
@@ -0,0 +1,12 @@
+int main() {
+  int x;
+  x = 1 +;
+  x = ;
+  return x;
+}
+int f( {
+  return 1;
+}
+int g() {
+  return y;
+}
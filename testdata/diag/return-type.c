@@ -0,0 +1,38 @@
+int missing(int x) {
+  if (x)
+    return 1;
+}
+
+int both(int x) {
+  if (x)
+    return 1;
+  else
+    return 2;
+}
+
+int nested(int x) {
+  if (x) {
+    if (x == 1)
+      return 1;
+    else
+      return 2;
+  } else {
+    return 3;
+  }
+}
+
+int forever(int x) {
+  while (1)
+    x = x + 1;
+}
+
+int counted(int x) {
+  for (; 2 > 1;)
+    x = x + 1;
+}
+
+int main() {
+  missing(1);
+  both(1);
+  nested(1);
+}
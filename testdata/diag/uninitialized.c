@@ -0,0 +1,66 @@
+int never() {
+  int x;
+  return x;
+}
+
+int branch(int c) {
+  int x;
+  if (c)
+    x = 1;
+  return x;
+}
+
+int after_for() {
+  int x;
+  int i;
+  for (i = 0; i < 3; i = i + 1)
+    x = i;
+  return x;
+}
+
+int after_while(int c) {
+  int x;
+  while (c) {
+    x = c;
+    c = c - 1;
+  }
+  return x;
+}
+
+int later_in_body() {
+  int i;
+  int x;
+  int sum;
+  sum = 0;
+  for (i = 0; i < 3; i = i + 1) {
+    sum = sum + x;
+    x = i;
+  }
+  return sum;
+}
+
+int assigned() {
+  int x;
+  int i;
+  x = 0;
+  for (i = 0; i < 3; i = i + 1)
+    x = x + i;
+  return x;
+}
+
+int escaped() {
+  int x;
+  int *p = &x;
+  *p = 1;
+  return x;
+}
+
+int array() {
+  int a[2];
+  a[0] = 1;
+  return a[1];
+}
+
+int main() {
+  return never() + branch(1) + after_for() + after_while(1) + later_in_body() + assigned() + escaped() + array();
+}
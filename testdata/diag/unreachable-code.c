@@ -0,0 +1,30 @@
+int f(int x) {
+  if (x)
+    return 1;
+  else
+    return 2;
+  x = 3;
+  return x;
+}
+
+int g(int x) {
+  return x;
+  ;
+  int y;
+}
+
+int h(int x) {
+  int y;
+  if (x)
+    y = 1;
+  else {
+    return 1;
+    y = 2;
+  }
+  return y;
+}
+
+int main() {
+  return f(1) + g(2) + h(3);
+  f(3);
+}
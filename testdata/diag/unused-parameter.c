@@ -0,0 +1,7 @@
+int f(int a, int b) {
+  return a;
+}
+
+int main() {
+  return f(1, 2);
+}
@@ -0,0 +1,6 @@
+int main() {
+  int used;
+  int unused;
+  used = 3;
+  return used;
+}
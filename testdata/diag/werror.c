@@ -0,0 +1,13 @@
+int f(int x) {
+  if (x)
+    return 1;
+}
+
+int g(int x) {
+  if (x)
+    return 2;
+}
+
+int main() {
+  return f(1) + g(1);
+}
@@ -0,0 +1,9 @@
+int main() {
+  int i;
+  int sum = 0;
+  for (i = 0; i < 10; i = i + 1)
+    if (i <= 5) sum = sum + i; else sum = sum - 1;
+  while (sum < 100)
+    sum = sum * 2;
+  return sum;
+}
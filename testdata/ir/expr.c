@@ -0,0 +1,6 @@
+int main() {
+  int a = 3;
+  int b;
+  b = a * (a + 4) / 2;
+  return b - (a == 3);
+}
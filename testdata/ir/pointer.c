@@ -0,0 +1,11 @@
+char s[4];
+int add(int x, int y) { return x + y; }
+int main() {
+  int a[3];
+  int *p = a;
+  char *c = s;
+  a[1] = 2;
+  *(p + 2) = 5;
+  c[0] = 1;
+  return add(a[1], p[2]) + (&a[2] - p) + *c;
+}
@@ -0,0 +1,19 @@
+int table[3];
+int scale = 10;
+static char *greeting;
+
+int first(char c) { return c; }
+
+int main() {
+  int a[4];
+  int *p = a;
+  int *q;
+  int i;
+  for (i = 0; i < 4; i = i + 1) {
+    table[i - i / 3 * 3] = i;
+    a[i] = table[i - i / 3 * 3] * scale;
+  }
+  q = p + 3;
+  greeting = "hi";
+  return *(q - 1) + (q - p) + first(greeting[1]) - 105;
+}
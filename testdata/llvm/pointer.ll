@@ -0,0 +1,86 @@
+; ModuleID = 'testdata/llvm/pointer.c'
+source_filename = "testdata/llvm/pointer.c"
+target triple = "x86_64-pc-linux-gnu"
+
+@.L..0 = private unnamed_addr constant [3 x i8] c"hi\00", align 1
+@greeting = internal global ptr null, align 8
+@scale = global i32 10, align 4
+@table = common global [3 x i32] zeroinitializer, align 4
+
+define i32 @first(i8 signext %c.arg) {
+entry:
+  %c = alloca i8, align 1
+  store i8 %c.arg, ptr %c, align 1
+  %.1 = load i8, ptr %c, align 1
+  %.2 = sext i8 %.1 to i32
+  ret i32 %.2
+}
+
+define i32 @main() {
+entry:
+  %a = alloca [4 x i32], align 4
+  %p = alloca ptr, align 8
+  %q = alloca ptr, align 8
+  %i = alloca i32, align 4
+  store ptr %a, ptr %p, align 8
+  store i32 0, ptr %i, align 4
+  br label %.Lbegin1
+.Lbegin1:
+  %.1 = load i32, ptr %i, align 4
+  %.2 = icmp slt i32 %.1, 4
+  %.3 = zext i1 %.2 to i32
+  %.4 = icmp ne i32 %.3, 0
+  br i1 %.4, label %.Lbody1, label %.Lend1
+.Lbody1:
+  %.5 = load i32, ptr %i, align 4
+  %.6 = load i32, ptr %i, align 4
+  %.7 = sdiv i32 %.6, 3
+  %.8 = mul i32 %.7, 3
+  %.9 = sub i32 %.5, %.8
+  %.10 = sext i32 %.9 to i64
+  %.11 = getelementptr i32, ptr @table, i64 %.10
+  %.12 = load i32, ptr %i, align 4
+  store i32 %.12, ptr %.11, align 4
+  %.13 = load i32, ptr %i, align 4
+  %.14 = sext i32 %.13 to i64
+  %.15 = getelementptr i32, ptr %a, i64 %.14
+  %.16 = load i32, ptr %i, align 4
+  %.17 = load i32, ptr %i, align 4
+  %.18 = sdiv i32 %.17, 3
+  %.19 = mul i32 %.18, 3
+  %.20 = sub i32 %.16, %.19
+  %.21 = sext i32 %.20 to i64
+  %.22 = getelementptr i32, ptr @table, i64 %.21
+  %.23 = load i32, ptr %.22, align 4
+  %.24 = load i32, ptr @scale, align 4
+  %.25 = mul i32 %.23, %.24
+  store i32 %.25, ptr %.15, align 4
+  %.26 = load i32, ptr %i, align 4
+  %.27 = add i32 %.26, 1
+  store i32 %.27, ptr %i, align 4
+  br label %.Lbegin1
+.Lend1:
+  %.28 = load ptr, ptr %p, align 8
+  %.29 = getelementptr i32, ptr %.28, i64 3
+  store ptr %.29, ptr %q, align 8
+  store ptr @.L..0, ptr @greeting, align 8
+  %.30 = load ptr, ptr %q, align 8
+  %.31 = getelementptr i32, ptr %.30, i64 -1
+  %.32 = load i32, ptr %.31, align 4
+  %.33 = load ptr, ptr %q, align 8
+  %.34 = load ptr, ptr %p, align 8
+  %.35 = ptrtoint ptr %.33 to i64
+  %.36 = ptrtoint ptr %.34 to i64
+  %.37 = sub i64 %.35, %.36
+  %.38 = trunc i64 %.37 to i32
+  %.39 = sdiv i32 %.38, 4
+  %.40 = add i32 %.32, %.39
+  %.41 = load ptr, ptr @greeting, align 8
+  %.42 = getelementptr i8, ptr %.41, i64 1
+  %.43 = load i8, ptr %.42, align 1
+  %.44 = call i32 (i8) @first(i8 signext %.43)
+  %.45 = add i32 %.40, %.44
+  %.46 = sub i32 %.45, 105
+  ret i32 %.46
+}
+
@@ -0,0 +1,7 @@
+int main() {
+  int x;
+  x = 3;
+  if (x * 2 == 6)
+    return x + 4 * 0;
+  return 1;
+}
@@ -0,0 +1,5 @@
+int sq(int x) { return x * x; }
+int clamp(int x) { if (x < 0) return 0; if (x > 9) return 9; return x; }
+inline int dist(int a, int b) { return clamp(sq(a) - sq(b)); }
+int sum(int n, int acc) { if (n == 0) return acc; return sum(n - 1, acc + n); }
+int main() { return dist(3, 2) + sum(10, 0); }
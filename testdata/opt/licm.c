@@ -0,0 +1,8 @@
+int f(int a, int b) {
+  int i;
+  int s;
+  s = 0;
+  for (i = 0; i < 10; i = i + 1)
+    s = s + a * b + a * b;
+  return s;
+}
@@ -0,0 +1,10 @@
+int a[10];
+
+int sum(int n) {
+  int i;
+  int s;
+  s = 0;
+  for (i = 0; i < n; i = i + 1)
+    s = s + a[i] * 8;
+  return s;
+}
@@ -0,0 +1,11 @@
+int g;
+int main() {
+    int x;
+    int y;
+    x = 3;
+    y = x * 4 + 2;
+    g = y;
+    if (y > 10)
+        return y - 1;
+    return 0;
+}
@@ -0,0 +1,48 @@
+.comm g, 4, 4
+.intel_syntax noprefix
+.text
+.global main
+.type main, @function
+main:
+	push rbp
+	mov rbp, rsp
+	sub rsp, 208
+	mov DWORD PTR [rbp - 4], 3
+	lea rax, [rbp - 8]
+	push rax
+	mov eax, [rbp - 4]
+	imul rax, rax, 4
+	add rax, 2
+	mov rdi, rax
+	pop rax
+	mov [rax], edi
+	lea rax, g[rip]
+	push rax
+	mov eax, [rbp - 8]
+	mov rdi, rax
+	pop rax
+	mov [rax], edi
+	mov eax, [rbp - 8]
+	mov rdi, rax
+	mov rax, 10
+	cmp rax, rdi
+	setl al
+	movzx rax, al
+	cmp rax, 0
+	je .Lelse1
+	mov eax, [rbp - 8]
+	sub rax, 1
+	mov rsp, rbp
+	pop rbp
+	ret
+	jmp .Lend1
+.Lelse1:
+.Lend1:
+	mov rax, 0
+	mov rsp, rbp
+	pop rbp
+	ret
+	mov rsp, rbp
+	pop rbp
+	ret
+.size main, .-main
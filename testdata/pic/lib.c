@@ -0,0 +1,5 @@
+int counter;
+int base = 10;
+static int hidden = 5;
+static int helper(int x) { return x + hidden; }
+int bump(int n) { counter = counter + n; return helper(counter) + base; }
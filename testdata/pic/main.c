@@ -0,0 +1,9 @@
+extern int counter;
+extern int base;
+int main() {
+    int r;
+    base = 100;
+    r = bump(3);
+    r = bump(4);
+    return r - counter;
+}
@@ -0,0 +1,7 @@
+int tentative;
+int zero = 0;
+int five = 5;
+char buf[32];
+const int answer = 42;
+const char letter = 97;
+int main() { return answer + five + zero + tentative + buf[0] + "x"[0]; }
@@ -0,0 +1,39 @@
+.section .rodata
+    .balign 1
+.L..0:
+    .byte 120
+    .byte 0
+.global letter
+    .balign 1
+.type letter, @object
+.size letter, 1
+letter:
+    .byte 97
+.global answer
+    .balign 4
+.type answer, @object
+.size answer, 4
+answer:
+    .byte 42
+    .byte 0
+    .byte 0
+    .byte 0
+.comm buf, 32, 16
+.data
+.global five
+    .balign 4
+.type five, @object
+.size five, 4
+five:
+    .byte 5
+    .byte 0
+    .byte 0
+    .byte 0
+.bss
+.global zero
+    .balign 4
+.type zero, @object
+.size zero, 4
+zero:
+    .zero 4
+.comm tentative, 4, 4
@@ -0,0 +1,4 @@
+int main() {
+  int x;
+  x = 3;
+}
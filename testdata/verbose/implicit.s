@@ -0,0 +1,27 @@
+.intel_syntax noprefix
+.text
+.global main
+.type main, @function
+main:
+	# prologue
+	# x @ rbp-4
+	push rbp
+	mov rbp, rsp
+	sub rsp, 208
+	# x = 3;
+	# x @ rbp-4
+	mov rax, rbp
+	sub rax, 4
+	push rax
+	push 3
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+	mov rax, 0
+	# epilogue
+	mov rsp, rbp
+	pop rbp
+	ret
+.size main, .-main
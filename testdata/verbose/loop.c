@@ -0,0 +1,9 @@
+int main() {
+  int i; int s = 0, t = 2;
+  for (i = 0; i < 10; i = i + 1) {
+    if (i == 3) s = s + t; else s = s + 1;
+  }
+  while (s > 100)
+    s = s - 1;
+  return s;
+}
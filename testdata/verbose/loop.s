@@ -0,0 +1,218 @@
+.intel_syntax noprefix
+.text
+.global main
+.type main, @function
+main:
+	# prologue
+	# i @ rbp-4
+	# s @ rbp-8
+	# t @ rbp-12
+	push rbp
+	mov rbp, rsp
+	sub rsp, 208
+	# s = 0
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	push 0
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+	# t = 2
+	# t @ rbp-12
+	mov rax, rbp
+	sub rax, 12
+	push rax
+	push 2
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+	# for (i = 0; i < 10; i = i + 1)
+	# i @ rbp-4
+	mov rax, rbp
+	sub rax, 4
+	push rax
+	push 0
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+.Lbegin1:	# begin of for (i = 0; i < 10; i = i + 1)
+	# i @ rbp-4
+	mov rax, rbp
+	sub rax, 4
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	push 10
+	pop rdi
+	pop rax
+	cmp rax, rdi
+	setl al
+	movzx rax, al
+	push rax
+	pop rax
+	cmp rax, 0
+	je .Lend1
+	# if (i == 3)
+	# i @ rbp-4
+	mov rax, rbp
+	sub rax, 4
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	push 3
+	pop rdi
+	pop rax
+	cmp rax, rdi
+	sete al
+	movzx rax, al
+	push rax
+	pop rax
+	cmp rax, 0
+	je .Lelse2
+	# s = s + t;
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	# t @ rbp-12
+	mov rax, rbp
+	sub rax, 12
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	pop rdi
+	pop rax
+	add rax, rdi
+	push rax
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+	jmp .Lend2
+.Lelse2:	# else of if (i == 3)
+	# s = s + 1;
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	push 1
+	pop rdi
+	pop rax
+	add rax, rdi
+	push rax
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+.Lend2:	# end of if (i == 3)
+	# i @ rbp-4
+	mov rax, rbp
+	sub rax, 4
+	push rax
+	# i @ rbp-4
+	mov rax, rbp
+	sub rax, 4
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	push 1
+	pop rdi
+	pop rax
+	add rax, rdi
+	push rax
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+	jmp .Lbegin1
+.Lend1:	# end of for (i = 0; i < 10; i = i + 1)
+	# while (s > 100)
+.Lbegin3:	# begin of while (s > 100)
+	push 100
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	pop rdi
+	pop rax
+	cmp rax, rdi
+	setl al
+	movzx rax, al
+	push rax
+	pop rax
+	cmp rax, 0
+	je .Lend3
+	# s = s - 1;
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	push 1
+	pop rdi
+	pop rax
+	sub rax, rdi
+	push rax
+	pop rdi
+	pop rax
+	mov [rax], edi
+	push rdi
+	pop rax
+	jmp .Lbegin3
+.Lend3:	# end of while (s > 100)
+	# return s;
+	# s @ rbp-8
+	mov rax, rbp
+	sub rax, 8
+	push rax
+	pop rax
+	mov eax, [rax]
+	push rax
+	pop rax
+	# epilogue
+	mov rsp, rbp
+	pop rbp
+	ret
+	# epilogue
+	mov rsp, rbp
+	pop rbp
+	ret
+.size main, .-main
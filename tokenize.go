@@ -1,4 +1,4 @@
-package main
+package g9cc
 
 import (
 	"fmt"
@@ -20,9 +20,40 @@ const (
 	tkChar
 	tkStr
 	tkSizeof
+	tkInline
+	tkConst
+	tkStatic
+	tkExtern
 	tkEOF
 )
 
+var tokenKindNames = [...]string{
+	tkPunct:  "punct",
+	tkReturn: "return",
+	tkIf:     "if",
+	tkElse:   "else",
+	tkWhile:  "while",
+	tkFor:    "for",
+	tkIdent:  "ident",
+	tkInt:    "int",
+	tkNum:    "num",
+	tkChar:   "char",
+	tkStr:    "str",
+	tkSizeof: "sizeof",
+	tkInline: "inline",
+	tkConst:  "const",
+	tkStatic: "static",
+	tkExtern: "extern",
+	tkEOF:    "eof",
+}
+
+func (k tokenKind) String() string {
+	if int(k) < len(tokenKindNames) {
+		return tokenKindNames[k]
+	}
+	return fmt.Sprintf("tokenKind(%d)", int(k))
+}
+
 type token struct {
 	kind tokenKind
 	next *token
@@ -88,6 +119,10 @@ func isDoublePunct(s string, i int) (string, bool) {
 	return tok, ok
 }
 
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
 func isIdentStart(b byte) bool {
 	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || b == '_'
 }
@@ -141,6 +176,14 @@ func scanIdentOrKeyword(s string, i int) (*token, int, bool) {
 		kind = tkSizeof
 	case "char":
 		kind = tkChar
+	case "inline":
+		kind = tkInline
+	case "const":
+		kind = tkConst
+	case "static":
+		kind = tkStatic
+	case "extern":
+		kind = tkExtern
 	}
 	return newToken(kind, ident, len(ident), i), j, true
 }
@@ -199,8 +242,8 @@ func tokenize(s string) (*token, error) {
 	i := 0
 
 	for i < len(s) {
-		// 空白の時スキップ
-		if s[i] == ' ' {
+		// 空白・改行の時スキップ
+		if isSpace(s[i]) {
 			i++
 			continue
 		}
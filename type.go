@@ -1,4 +1,6 @@
-package main
+package g9cc
+
+import "fmt"
 
 type typekind int
 
@@ -17,6 +19,7 @@ type ty struct {
 	name     *token
 	size     int
 	arrayLen int
+	isConst  bool // const 修飾
 }
 
 func pointerTo(base *ty) *ty {
@@ -45,3 +48,52 @@ func funcType(returnTy *ty) *ty {
 		size:     1,
 	}
 }
+
+// String は診断メッセージ向けに C 風の型名を返す
+func (t *ty) String() string {
+	if t == nil {
+		return "<unknown>"
+	}
+	switch t.kind {
+	case tyInt, tyChar:
+		name := "int"
+		if t.kind == tyChar {
+			name = "char"
+		}
+		if t.isConst {
+			return "const " + name
+		}
+		return name
+	case tyPtr:
+		return t.base.String() + "*"
+	case tyArray:
+		dims := ""
+		for t.kind == tyArray {
+			dims += fmt.Sprintf("[%d]", t.arrayLen)
+			t = t.base
+		}
+		return t.String() + dims
+	case tyFunc:
+		return t.returnTy.String() + "()"
+	}
+	return "<unknown>"
+}
+
+// align は型のアラインメントを返す
+func (t *ty) align() int {
+	switch t.kind {
+	case tyArray:
+		return t.base.align()
+	case tyFunc:
+		return 1
+	}
+	return t.size
+}
+
+// readOnly は書き換えられない型（const、要素が const の配列）かどうかを返す
+func (t *ty) readOnly() bool {
+	if t.kind == tyArray {
+		return t.base.readOnly()
+	}
+	return t.isConst
+}
@@ -0,0 +1,235 @@
+package g9cc
+
+import "fmt"
+
+// ローカル変数の初期化を文の実行順に追い、未初期化のまま読まれる変数を警告する。
+// ループ先頭の状態は入口と本体末尾の合流なので、警告を出さずに本体を
+// may が増えなくなるまで繰り返して求め、その状態から本体をもう一度調べて警告する。
+// 後の周回での代入が届く読み出しは may の警告になり、ループの後の状態にも本体の may が入る。
+
+// initState はある地点で代入済みの変数の集合
+type initState struct {
+	must map[*obj]bool // すべての経路で代入済み
+	may  map[*obj]bool // いずれかの経路で代入済み
+	dead bool          // 到達しない地点（return の後）
+}
+
+func newInitState() *initState {
+	return &initState{must: map[*obj]bool{}, may: map[*obj]bool{}}
+}
+
+func (s *initState) clone() *initState {
+	t := newInitState()
+	for v := range s.must {
+		t.must[v] = true
+	}
+	for v := range s.may {
+		t.may[v] = true
+	}
+	t.dead = s.dead
+	return t
+}
+
+func (s *initState) assign(v *obj) {
+	s.must[v] = true
+	s.may[v] = true
+}
+
+// join は2つの経路の合流点の状態を返す
+func join(a, b *initState) *initState {
+	if a.dead {
+		return b
+	}
+	if b.dead {
+		return a
+	}
+	t := newInitState()
+	for v := range a.must {
+		if b.must[v] {
+			t.must[v] = true
+		}
+	}
+	for v := range a.may {
+		t.may[v] = true
+	}
+	for v := range b.may {
+		t.may[v] = true
+	}
+	return t
+}
+
+type initChecker struct {
+	c       *checker
+	tracked map[*obj]bool // 追跡するローカル変数（引数・配列・アドレスを取られた変数は除く）
+	warned  map[*obj]bool
+	quiet   bool // ループ先頭の状態を求める間は警告しない
+}
+
+// checkUninit は関数内の未初期化変数の読み出しを警告する
+func (c *checker) checkUninit(fn *obj) error {
+	ic := &initChecker{c: c, tracked: map[*obj]bool{}, warned: map[*obj]bool{}}
+	params := map[*obj]bool{}
+	for v := fn.params; v != nil; v = v.next {
+		params[v] = true
+	}
+	for v := fn.locals; v != nil; v = v.next {
+		if !params[v] && v.ty.kind != tyArray {
+			ic.tracked[v] = true
+		}
+	}
+	// &x で逃げた変数はポインタ経由で初期化されうるので追跡しない
+	var escape func(n *node)
+	escape = func(n *node) {
+		if n.kind == ndAddr && n.lhs.kind == ndVar {
+			delete(ic.tracked, n.lhs.lvar)
+		}
+		forEachChild(n, escape)
+	}
+	escape(fn.body)
+
+	_, err := ic.stmt(fn.body, newInitState())
+	return err
+}
+
+func (ic *initChecker) stmt(n *node, s *initState) (*initState, error) {
+	var err error
+	switch n.kind {
+	case ndBlock:
+		for stmt := n.lhs; stmt != nil; stmt = stmt.next {
+			if s, err = ic.stmt(stmt, s); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case ndExprStmt:
+		return s, ic.expr(n.lhs, s)
+	case ndReturn:
+		if err := ic.expr(n.lhs, s); err != nil {
+			return nil, err
+		}
+		s = s.clone()
+		s.dead = true
+		return s, nil
+	case ndIf:
+		if err := ic.expr(n.cond, s); err != nil {
+			return nil, err
+		}
+		then, err := ic.stmt(n.then, s.clone())
+		if err != nil {
+			return nil, err
+		}
+		els := s
+		if n.els != nil {
+			if els, err = ic.stmt(n.els, s.clone()); err != nil {
+				return nil, err
+			}
+		}
+		return join(then, els), nil
+	case ndWhile:
+		return ic.loop(s, n.lhs, func(s *initState) (*initState, error) {
+			return ic.stmt(n.rhs, s)
+		})
+	case ndFor:
+		if n.init != nil {
+			if err := ic.expr(n.init, s); err != nil {
+				return nil, err
+			}
+		}
+		return ic.loop(s, n.cond, func(s *initState) (*initState, error) {
+			if n.then != nil {
+				if s, err = ic.stmt(n.then, s); err != nil {
+					return nil, err
+				}
+			}
+			if n.inc != nil && !s.dead {
+				if err := ic.expr(n.inc, s); err != nil {
+					return nil, err
+				}
+			}
+			return s, nil
+		})
+	}
+	return s, ic.expr(n, s)
+}
+
+// loop は入口の状態 s からループを調べ、ループを抜けた後の状態を返す。
+// cond は nil なら無条件、body は条件の後の本体（for なら inc まで）を調べる。
+func (ic *initChecker) loop(s *initState, cond *node, body func(*initState) (*initState, error)) (*initState, error) {
+	iter := func(head *initState) (*initState, error) {
+		t := head.clone()
+		if cond != nil {
+			if err := ic.expr(cond, t); err != nil {
+				return nil, err
+			}
+		}
+		return body(t)
+	}
+
+	quiet := ic.quiet
+	ic.quiet = true
+	head := s
+	for {
+		end, err := iter(head)
+		if err != nil {
+			return nil, err
+		}
+		next := join(s.clone(), end)
+		if len(next.may) == len(head.may) {
+			break
+		}
+		head = next
+	}
+	ic.quiet = quiet
+
+	if _, err := iter(head); err != nil {
+		return nil, err
+	}
+	exit := head.clone()
+	if cond != nil {
+		ic.quiet = true
+		err := ic.expr(cond, exit)
+		ic.quiet = quiet
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exit, nil
+}
+
+// expr は式を評価順にたどり、変数の読み出しと代入を s に反映する
+func (ic *initChecker) expr(n *node, s *initState) error {
+	switch n.kind {
+	case ndAssign:
+		if n.lhs.kind == ndVar {
+			if err := ic.expr(n.rhs, s); err != nil {
+				return err
+			}
+			s.assign(n.lhs.lvar)
+			return nil
+		}
+	case ndVar:
+		return ic.read(n, s)
+	}
+	var err error
+	forEachChild(n, func(child *node) {
+		if err == nil {
+			err = ic.expr(child, s)
+		}
+	})
+	return err
+}
+
+func (ic *initChecker) read(n *node, s *initState) error {
+	v := n.lvar
+	if ic.quiet || s.dead || !ic.tracked[v] || s.must[v] || ic.warned[v] {
+		return nil
+	}
+	ic.warned[v] = true
+
+	msg := fmt.Sprintf("'%s' is used uninitialized", *v.name)
+	if s.may[v] {
+		msg = fmt.Sprintf("'%s' may be used uninitialized", *v.name)
+	}
+	return ic.c.diags.warnWithNote(WarnUninitialized, ic.c.input, n.tok.pos, msg,
+		v.tok.pos, fmt.Sprintf("'%s' was declared here", *v.name))
+}
@@ -0,0 +1,52 @@
+package g9cc
+
+import "fmt"
+
+// varUse は関数内でのローカル変数の使われ方
+type varUse struct {
+	read map[*obj]bool // 値を読んだ（アドレスを取った場合も含む）
+	set  map[*obj]bool // 代入された
+}
+
+func (u *varUse) visit(n *node) {
+	// 変数への代入は読み出しとして数えない
+	if n.kind == ndAssign && n.lhs.kind == ndVar {
+		u.set[n.lhs.lvar] = true
+		u.visit(n.rhs)
+		return
+	}
+	if n.kind == ndVar {
+		u.read[n.lvar] = true
+	}
+	forEachChild(n, u.visit)
+}
+
+// warnUnused は使われていないローカル変数と引数を警告する
+func (c *checker) warnUnused(fn *obj) error {
+	u := &varUse{read: map[*obj]bool{}, set: map[*obj]bool{}}
+	u.visit(fn.body)
+
+	params := map[*obj]bool{}
+	for v := fn.params; v != nil; v = v.next {
+		params[v] = true
+	}
+
+	for v := fn.locals; v != nil; v = v.next {
+		if u.read[v] || v.tok == nil {
+			continue
+		}
+		var err error
+		switch {
+		case params[v]:
+			err = c.diags.warn(WarnUnusedParameter, c.input, v.tok.pos, fmt.Sprintf("unused parameter '%s'", *v.name))
+		case u.set[v]:
+			err = c.diags.warn(WarnUnusedVariable, c.input, v.tok.pos, fmt.Sprintf("variable '%s' set but not used", *v.name))
+		default:
+			err = c.diags.warn(WarnUnusedVariable, c.input, v.tok.pos, fmt.Sprintf("unused variable '%s'", *v.name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package g9cc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ソースを注釈したアセンブリの出力（-fverbose-asm）。
+// 文のコードの前にその文のソースを、ローカル変数のアドレスを求める前に変数名とフレーム上の位置を
+// コメントで書き、プロローグ・エピローグと制御構造のラベルに印を付ける。
+// コメントは命令の意味に関わらない行なので、peephole 最適化は読み飛ばす。
+
+// verboseInfo は -fverbose-asm のときのコード生成の状態
+type verboseInfo struct {
+	lastLine int // IR のコード生成で最後にソースを書いた行
+}
+
+// comment はコメントの行を積む（-fverbose-asm のときだけ）
+func (g *generator) comment(format string, args ...any) {
+	if g.verbose == nil {
+		return
+	}
+	g.insts = append(g.insts, &asmInst{kind: asmDirective, text: "\t# " + fmt.Sprintf(format, args...), meta: true})
+}
+
+// markedLabel はラベルを置き、-fverbose-asm ならそれが属する構文を行末に書く
+func (g *generator) markedLabel(construct, format string, args ...any) {
+	g.label(format, args...)
+	if g.verbose != nil {
+		g.insts[len(g.insts)-1].comment = construct
+	}
+}
+
+// commentStmt は文のソースを書く（AST のコード生成で文ごとに使う）
+func (g *generator) commentStmt(node *node) {
+	if g.verbose != nil {
+		g.comment("%s", g.stmtText(node))
+	}
+}
+
+// commentLine は行が変わったときにその行のソースを書く（IR の命令ごとに使う）
+func (g *generator) commentLine(tok *token) {
+	if g.verbose == nil || tok == nil {
+		return
+	}
+	line, _ := g.src.position(tok)
+	if line == g.verbose.lastLine {
+		return
+	}
+	g.verbose.lastLine = line
+	g.comment("%d: %s", line, strings.TrimSpace(g.src.line(line)))
+}
+
+// commentLocal はローカル変数の名前とフレーム上の位置を書く
+func (g *generator) commentLocal(v *obj) {
+	g.comment("%s @ rbp-%d", *v.name, v.offset)
+}
+
+// commentPrologue はプロローグの印とフレーム上の変数の配置を書く
+func (g *generator) commentPrologue(fn *obj) {
+	if g.verbose == nil {
+		return
+	}
+	g.comment("prologue")
+	params, locals := frameVars(fn)
+	for _, v := range params {
+		g.comment("%s @ rbp-%d (parameter)", *v.name, v.offset)
+	}
+	for _, v := range locals {
+		g.commentLocal(v)
+	}
+}
+
+// frameVars は関数の引数と、引数以外のローカル変数を宣言した順に返す
+func frameVars(fn *obj) (params, locals []*obj) {
+	for v := fn.params; v != nil; v = v.next {
+		params = append(params, v)
+	}
+	for v := fn.locals; v != nil; v = v.next {
+		if !slices.Contains(params, v) {
+			locals = append(locals, v)
+		}
+	}
+	slices.Reverse(locals)
+	return params, locals
+}
+
+// stmtText は文のソースを 1 行にして返す。if・while・for は条件の ")" まで、
+// 宣言の初期化子は変数名から初期値の終わりまでを返す。
+func (g *generator) stmtText(node *node) string {
+	start := node.tok
+	isInit := node.kind == ndExprStmt && node.lhs.isInit
+	if isInit {
+		start = node.lhs.lhs.tok
+	}
+	header := node.kind == ndIf || node.kind == ndWhile || node.kind == ndFor
+
+	end := start
+	depth := 0
+loop:
+	for t := start; t != nil && t.kind != tkEOF; t = t.next {
+		if t.kind == tkPunct {
+			switch t.str {
+			case "(", "[":
+				depth++
+			case ")", "]":
+				depth--
+				if header && depth == 0 {
+					end = t
+					break loop
+				}
+			case ",":
+				if isInit && depth == 0 {
+					break loop
+				}
+			case ";":
+				if depth == 0 {
+					if !isInit {
+						end = t
+					}
+					break loop
+				}
+			}
+		}
+		end = t
+	}
+
+	lines := strings.Split(g.src.input[start.pos:end.pos+end.len], "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, " ")
+}
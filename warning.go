@@ -0,0 +1,57 @@
+package g9cc
+
+// 警告カテゴリ。名前は -W<name> / -Wno-<name> で指定する。
+const (
+	WarnUnusedVariable       = "unused-variable"
+	WarnUnusedParameter      = "unused-parameter"
+	WarnImplicitFunctionDecl = "implicit-function-declaration"
+	WarnPointerIntegerCmp    = "pointer-integer-compare"
+	WarnParentheses          = "parentheses"
+	WarnReturnType           = "return-type"
+	WarnUnreachableCode      = "unreachable-code"
+	WarnUninitialized        = "uninitialized"
+)
+
+// warningDefaults は各カテゴリの既定の有効・無効。-Wall はすべてを有効にする。
+var warningDefaults = map[string]bool{
+	WarnUnusedVariable:       false,
+	WarnUnusedParameter:      false,
+	WarnImplicitFunctionDecl: true,
+	WarnPointerIntegerCmp:    true,
+	WarnParentheses:          false,
+	WarnReturnType:           true,
+	WarnUnreachableCode:      false,
+	WarnUninitialized:        true,
+}
+
+// WarningCategories は既知の警告カテゴリ名を返す
+func WarningCategories() []string {
+	return []string{
+		WarnUnusedVariable,
+		WarnUnusedParameter,
+		WarnImplicitFunctionDecl,
+		WarnPointerIntegerCmp,
+		WarnParentheses,
+		WarnReturnType,
+		WarnUnreachableCode,
+		WarnUninitialized,
+	}
+}
+
+// IsWarningCategory は name が既知の警告カテゴリかどうかを返す
+func IsWarningCategory(name string) bool {
+	_, ok := warningDefaults[name]
+	return ok
+}
+
+// enabledWarnings は Options.Warnings で上書きした有効な警告の集合を返す
+func (o Options) enabledWarnings() map[string]bool {
+	enabled := make(map[string]bool, len(warningDefaults))
+	for name, on := range warningDefaults {
+		if v, ok := o.Warnings[name]; ok {
+			on = v
+		}
+		enabled[name] = on
+	}
+	return enabled
+}
@@ -0,0 +1,542 @@
+package g9cc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// WebAssembly 向けのコード生成（-target wasm32-wasi）。型付き AST を WAT（テキスト形式）のモジュールにする。
+//
+//   - 値は wasm のオペランドスタックに i64 で積む。ポインタの大きさ（8 バイト）はフロントエンドと同じ
+//   - 関数の引数と戻り値は i32（int, char, ポインタはどれも 32 ビットに収まる）
+//   - グローバル変数と文字列リテラルは線形メモリの wasmDataBase から順に置く
+//   - ローカル変数は wasm のローカル変数に置く。アドレスを取られる変数や配列のある関数では、
+//     すべてをシャドウスタック（線形メモリ上で $.sp から下に伸びる）に置く
+//   - 定義のない関数は "env" モジュールからインポートする
+//   - main があれば、その戻り値で WASI の proc_exit を呼ぶ _start をエクスポートする
+
+const (
+	wasmDataBase  = 1024    // 0 番地付近はヌルポインタの参照で壊さないよう空けておく
+	wasmStackSize = 1 << 20 // シャドウスタックの大きさ
+	wasmPageSize  = 65536
+)
+
+type wasmGenerator struct {
+	w     *bufio.Writer
+	depth int // 字下げの深さ
+	cnt   int
+
+	addrs   map[*obj]int    // グローバル変数の線形メモリ上のアドレス
+	defined map[string]*obj // このファイルで定義した関数
+	locals  map[*obj]string // wasm のローカル変数に置いた変数の名前
+	frame   bool            // この関数がシャドウスタックにフレームを持つか
+}
+
+// line は字下げして 1 行書く
+func (g *wasmGenerator) line(format string, args ...any) {
+	g.w.WriteString(strings.Repeat("  ", g.depth))
+	fmt.Fprintf(g.w, format, args...)
+	g.w.WriteByte('\n')
+}
+
+func (g *wasmGenerator) count() int {
+	g.cnt++
+	return g.cnt
+}
+
+// wasmName は識別子を WAT の名前にする
+func wasmName(name string) string {
+	return "$" + name
+}
+
+// wasmString はバイト列を WAT の文字列リテラルにする
+func wasmString(data string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c >= 0x20 && c < 0x7f && c != '"' && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "\\%02x", c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// layoutData はグローバル変数に線形メモリのアドレスを割り当て、データの終わりを返す
+func (g *wasmGenerator) layoutData(prog *obj) int {
+	addr := wasmDataBase
+	for v := prog; v != nil; v = v.next {
+		if v.isFunction || v.isExtern {
+			continue
+		}
+		addr = alignTo(addr, globalAlign(v.ty))
+		g.addrs[v] = addr
+		addr += v.ty.size
+	}
+	return addr
+}
+
+// imports は呼び出しているのに定義のない関数を、初めて呼ぶ箇所の引数の数と一緒に返す
+func (g *wasmGenerator) imports(prog *obj) ([]*node, error) {
+	var calls []*node
+	seen := map[string]*node{}
+	var err error
+	for fn := prog; fn != nil; fn = fn.next {
+		if !fn.isFunction || fn.body == nil {
+			continue
+		}
+		walkNodes(fn.body, func(n *node) {
+			if n.kind != ndFuncall || g.defined[n.funcname] != nil {
+				return
+			}
+			first, ok := seen[n.funcname]
+			if !ok {
+				seen[n.funcname] = n
+				calls = append(calls, n)
+				return
+			}
+			if len(first.args) != len(n.args) && err == nil {
+				err = fmt.Errorf("%s is called with %d and %d arguments; wasm imports need one signature", n.funcname, len(first.args), len(n.args))
+			}
+		})
+	}
+	return calls, err
+}
+
+// signature は i32 の引数 n 個と i32 の戻り値の型を書く
+func signature(n int, names func(i int) string) string {
+	var sb strings.Builder
+	for i := range n {
+		if names != nil {
+			fmt.Fprintf(&sb, " (param %s i32)", names(i))
+		} else {
+			sb.WriteString(" (param i32)")
+		}
+	}
+	sb.WriteString(" (result i32)")
+	return sb.String()
+}
+
+// fromI32 は境界で受け取った i32 を ty 型の i64 の値にする
+func (g *wasmGenerator) fromI32(ty *ty) {
+	switch ty.kind {
+	case tyPtr:
+		g.line("i64.extend_i32_u")
+	case tyChar:
+		g.line("i64.extend_i32_s")
+		g.line("i64.extend8_s")
+	default:
+		g.line("i64.extend_i32_s")
+	}
+}
+
+// truncate は wasm のローカル変数に置く値を ty 型の範囲に切り詰める（メモリでは store の幅で切れる）
+func (g *wasmGenerator) truncate(ty *ty) {
+	switch ty.kind {
+	case tyInt:
+		g.line("i32.wrap_i64")
+		g.line("i64.extend_i32_s")
+	case tyChar:
+		g.line("i64.extend8_s")
+	}
+}
+
+// load は i32 のアドレスが指す ty 型の値を i64 で読む（int と char は符号拡張する）
+func (g *wasmGenerator) load(ty *ty) {
+	switch ty.size {
+	case 8:
+		g.line("i64.load")
+	case 4:
+		g.line("i64.load32_s")
+	case 1:
+		g.line("i64.load8_s")
+	}
+}
+
+// store はスタックの i32 のアドレスに i64 の値を ty 型の幅で書く
+func (g *wasmGenerator) store(ty *ty) {
+	switch ty.size {
+	case 8:
+		g.line("i64.store")
+	case 4:
+		g.line("i64.store32")
+	case 1:
+		g.line("i64.store8")
+	}
+}
+
+// frameAddr はシャドウスタック上の変数のアドレス（i32）を積む
+func (g *wasmGenerator) frameAddr(offset int) {
+	g.line("local.get $.fp")
+	g.line("i32.const %d", offset)
+	g.line("i32.sub")
+}
+
+// addr は左辺値の i32 のアドレスを積む
+func (g *wasmGenerator) addr(node *node) error {
+	switch node.kind {
+	case ndVar:
+		v := node.lvar
+		if v.isLocal {
+			g.frameAddr(v.offset)
+			return nil
+		}
+		if v.isExtern {
+			return fmt.Errorf("extern variable %s is not supported for target wasm32-wasi", *v.name)
+		}
+		g.line("i32.const %d", g.addrs[v])
+		return nil
+	case ndDeref:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.line("i32.wrap_i64")
+		return nil
+	}
+
+	// 左辺値の検査は sema で済んでいる
+	return fmt.Errorf("internal error: not an lvalue: %d", node.kind)
+}
+
+var wasmBinOps = map[nodeKind]string{
+	ndAdd: "i64.add", ndSub: "i64.sub", ndMul: "i64.mul", ndDiv: "i64.div_s",
+	ndEq: "i64.eq", ndNe: "i64.ne", ndLt: "i64.lt_s", ndLe: "i64.le_s",
+}
+
+// genExpr は式の値を i64 で積む
+func (g *wasmGenerator) genExpr(node *node) error {
+	switch node.kind {
+	case ndNum:
+		g.line("i64.const %d", node.val)
+		return nil
+	case ndVar:
+		if name, ok := g.locals[node.lvar]; ok {
+			g.line("local.get %s", name)
+			return nil
+		}
+		if err := g.addr(node); err != nil {
+			return err
+		}
+		if node.ty.kind == tyArray {
+			g.line("i64.extend_i32_u")
+			return nil
+		}
+		g.load(node.ty)
+		return nil
+	case ndDeref:
+		if err := g.addr(node); err != nil {
+			return err
+		}
+		if node.ty.kind == tyArray {
+			g.line("i64.extend_i32_u")
+			return nil
+		}
+		g.load(node.ty)
+		return nil
+	case ndAddr:
+		if err := g.addr(node.lhs); err != nil {
+			return err
+		}
+		g.line("i64.extend_i32_u")
+		return nil
+	case ndAssign:
+		if node.lhs.kind == ndVar {
+			if name, ok := g.locals[node.lhs.lvar]; ok {
+				if err := g.genExpr(node.rhs); err != nil {
+					return err
+				}
+				g.truncate(node.lhs.ty)
+				g.line("local.tee %s", name)
+				return nil
+			}
+		}
+		if err := g.addr(node.lhs); err != nil {
+			return err
+		}
+		if err := g.genExpr(node.rhs); err != nil {
+			return err
+		}
+		g.line("local.tee $.tmp")
+		g.store(node.lhs.ty)
+		g.line("local.get $.tmp")
+		return nil
+	case ndFuncall:
+		for _, arg := range node.args {
+			if err := g.genExpr(arg); err != nil {
+				return err
+			}
+			g.line("i32.wrap_i64")
+		}
+		g.line("call %s", wasmName(node.funcname))
+		g.fromI32(node.ty)
+		return nil
+	}
+
+	op, ok := wasmBinOps[node.kind]
+	if !ok {
+		return fmt.Errorf("internal error: unexpected node kind: %d", node.kind)
+	}
+	if err := g.genExpr(node.lhs); err != nil {
+		return err
+	}
+	if err := g.genExpr(node.rhs); err != nil {
+		return err
+	}
+	g.line("%s", op)
+	switch node.kind {
+	case ndEq, ndNe, ndLt, ndLe:
+		// 比較の結果は i32
+		g.line("i64.extend_i32_u")
+	}
+	return nil
+}
+
+// cond は条件式を i32 の真偽値として積む
+func (g *wasmGenerator) cond(node *node) error {
+	if err := g.genExpr(node); err != nil {
+		return err
+	}
+	g.line("i64.const 0")
+	g.line("i64.ne")
+	return nil
+}
+
+// loop は while と for の本体を block と loop で囲んで出力する
+func (g *wasmGenerator) loop(cond, body, inc *node) error {
+	cnt := g.count()
+	g.line("block $Lend%d", cnt)
+	g.depth++
+	g.line("loop $Lbegin%d", cnt)
+	g.depth++
+	if cond != nil {
+		if err := g.cond(cond); err != nil {
+			return err
+		}
+		g.line("i32.eqz")
+		g.line("br_if $Lend%d", cnt)
+	}
+	if body != nil {
+		if err := g.genStmt(body); err != nil {
+			return err
+		}
+	}
+	if inc != nil {
+		if err := g.genExpr(inc); err != nil {
+			return err
+		}
+		g.line("drop")
+	}
+	g.line("br $Lbegin%d", cnt)
+	g.depth--
+	g.line("end")
+	g.depth--
+	g.line("end")
+	return nil
+}
+
+func (g *wasmGenerator) genStmt(node *node) error {
+	switch node.kind {
+	case ndExprStmt:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.line("drop")
+		return nil
+	case ndReturn:
+		if err := g.genExpr(node.lhs); err != nil {
+			return err
+		}
+		g.line("i32.wrap_i64")
+		g.epilogue()
+		g.line("return")
+		return nil
+	case ndIf:
+		if err := g.cond(node.cond); err != nil {
+			return err
+		}
+		g.line("if")
+		g.depth++
+		if err := g.genStmt(node.then); err != nil {
+			return err
+		}
+		g.depth--
+		if node.els != nil {
+			g.line("else")
+			g.depth++
+			if err := g.genStmt(node.els); err != nil {
+				return err
+			}
+			g.depth--
+		}
+		g.line("end")
+		return nil
+	case ndWhile:
+		return g.loop(node.lhs, node.rhs, nil)
+	case ndFor:
+		if node.init != nil {
+			if err := g.genExpr(node.init); err != nil {
+				return err
+			}
+			g.line("drop")
+		}
+		return g.loop(node.cond, node.then, node.inc)
+	case ndBlock:
+		for n := node.lhs; n != nil; n = n.next {
+			if err := g.genStmt(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("internal error: invalid statement: %d", node.kind)
+	}
+}
+
+// epilogue はシャドウスタックのフレームを畳む
+func (g *wasmGenerator) epilogue() {
+	if g.frame {
+		g.line("local.get $.fp")
+		g.line("global.set $.sp")
+	}
+}
+
+// assignLocals はローカル変数を wasm のローカル変数に置く。配列か & を取られる変数があれば、
+// ポインタ演算で隣の変数に届くコードのためにフレームの配置を x86-64 と同じに保ち、
+// すべてシャドウスタックに置く。
+func (g *wasmGenerator) assignLocals(fn *obj) []*obj {
+	params, locals := frameVars(fn)
+	vars := append(params, locals...)
+	g.locals = map[*obj]string{}
+	g.frame = addressTaken(fn) || slices.ContainsFunc(vars, func(v *obj) bool { return v.ty.kind == tyArray })
+	if g.frame {
+		return nil
+	}
+
+	used := map[string]bool{}
+	for _, v := range vars {
+		name := wasmName(*v.name)
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("$%s.%d", *v.name, i)
+		}
+		used[name] = true
+		g.locals[v] = name
+	}
+	return vars
+}
+
+func (g *wasmGenerator) genFunc(fn *obj) error {
+	regs := g.assignLocals(fn)
+	params, _ := frameVars(fn)
+
+	export := ""
+	if !fn.isStatic {
+		export = fmt.Sprintf(" (export %q)", *fn.name)
+	}
+	argName := func(i int) string { return fmt.Sprintf("$%s.arg", *params[i].name) }
+	g.line("(func %s%s%s", wasmName(*fn.name), export, signature(len(params), argName))
+	g.depth++
+	g.line("(local $.fp i32) (local $.tmp i64)")
+	for _, v := range regs {
+		g.line("(local %s i64)", g.locals[v])
+	}
+
+	// プロローグ。シャドウスタックにフレームを取り、引数を置き場所に移す
+	if g.frame {
+		g.line("global.get $.sp")
+		g.line("local.tee $.fp")
+		g.line("i32.const %d", frameSize(fn))
+		g.line("i32.sub")
+		g.line("global.set $.sp")
+	}
+	for i, v := range params {
+		if name, ok := g.locals[v]; ok {
+			g.line("local.get %s", argName(i))
+			g.fromI32(v.ty)
+			g.line("local.set %s", name)
+			continue
+		}
+		g.frameAddr(v.offset)
+		g.line("local.get %s", argName(i))
+		g.fromI32(v.ty)
+		g.store(v.ty)
+	}
+
+	if err := g.genStmt(fn.body); err != nil {
+		return err
+	}
+	// 末尾まで来たら 0 を返す
+	g.epilogue()
+	g.line("i32.const 0")
+	g.depth--
+	g.line(")")
+	return nil
+}
+
+// codegenWasm は型付き AST から WebAssembly のモジュール（WAT）を生成する
+func codegenWasm(prog *obj, w io.Writer) error {
+	g := &wasmGenerator{w: bufio.NewWriter(w), addrs: map[*obj]int{}, defined: map[string]*obj{}}
+	for fn := prog; fn != nil; fn = fn.next {
+		if fn.isFunction && fn.body != nil {
+			g.defined[*fn.name] = fn
+		}
+	}
+	imports, err := g.imports(prog)
+	if err != nil {
+		return err
+	}
+	dataEnd := g.layoutData(prog)
+	stackTop := alignTo(dataEnd, 16) + wasmStackSize
+
+	g.line("(module")
+	g.depth++
+	for _, call := range imports {
+		g.line("(import \"env\" %q (func %s%s))", call.funcname, wasmName(call.funcname), signature(len(call.args), nil))
+	}
+	main := g.defined["main"]
+	if main != nil {
+		g.line("(import \"wasi_snapshot_preview1\" \"proc_exit\" (func $.proc_exit (param i32)))")
+	}
+	g.line("(memory (export \"memory\") %d)", alignTo(stackTop, wasmPageSize)/wasmPageSize)
+	g.line("(global $.sp (mut i32) (i32.const %d))", stackTop)
+	for v := prog; v != nil; v = v.next {
+		if v.isFunction || v.isExtern || v.initData == nil {
+			continue
+		}
+		// 0 で初期化した変数はメモリの初期値のままでよい
+		if strings.Trim(*v.initData, "\x00") == "" {
+			continue
+		}
+		g.line("(data (i32.const %d) %s)", g.addrs[v], wasmString(*v.initData))
+	}
+
+	for fn := prog; fn != nil; fn = fn.next {
+		if !fn.isFunction || fn.body == nil {
+			continue
+		}
+		if err := g.genFunc(fn); err != nil {
+			return err
+		}
+	}
+
+	if main != nil {
+		params, _ := frameVars(main)
+		g.line("(func $.start (export \"_start\")")
+		g.depth++
+		for range params {
+			g.line("i32.const 0")
+		}
+		g.line("call $main")
+		g.line("call $.proc_exit")
+		g.depth--
+		g.line(")")
+	}
+	g.depth--
+	g.line(")")
+	return g.w.Flush()
+}